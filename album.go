@@ -0,0 +1,78 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Фото альбома приходят отдельными обновлениями; альбом считается полным,
+// если за это время не пришло новых частей
+const albumWait = time.Second
+
+// albumCollector собирает части альбомов (MediaGroupID) в один запрос
+type albumCollector struct {
+	mu     sync.Mutex
+	albums map[string]*album
+}
+
+type album struct {
+	messages []*tgbotapi.Message
+	timer    *time.Timer
+}
+
+func newAlbumCollector() *albumCollector {
+	return &albumCollector{albums: make(map[string]*album)}
+}
+
+// Add adds a part of an album and calls done with all parts, ordered by
+// message ID, once no new parts arrive for albumWait.
+func (c *albumCollector) Add(message *tgbotapi.Message, done func([]*tgbotapi.Message)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	id := message.MediaGroupID
+	a, exists := c.albums[id]
+	// Если таймер уже сработал, его обработчик ждёт мьютекс и заберёт только
+	// прежние части; Reset запустил бы его второй раз, поэтому начинается новый альбом
+	if exists && !a.timer.Stop() {
+		exists = false
+	}
+	if !exists {
+		a = &album{}
+		c.albums[id] = a
+		a.timer = time.AfterFunc(albumWait, func() {
+			c.mu.Lock()
+			if c.albums[id] == a {
+				delete(c.albums, id)
+			}
+			messages := a.messages
+			c.mu.Unlock()
+
+			sort.Slice(messages, func(i, j int) bool { return messages[i].MessageID < messages[j].MessageID })
+			done(messages)
+		})
+	} else {
+		a.timer.Reset(albumWait)
+	}
+	a.messages = append(a.messages, message)
+}
+
+// albumMessage объединяет части альбома в одно сообщение с подписью альбома
+// и возвращает file_id всех фото
+func albumMessage(parts []*tgbotapi.Message) (*tgbotapi.Message, []string) {
+	message := *parts[0]
+	var photos []string
+	for _, part := range parts {
+		if message.Caption == "" && part.Caption != "" {
+			message.Caption = part.Caption
+			message.CaptionEntities = part.CaptionEntities
+		}
+		if len(part.Photo) > 0 {
+			photos = append(photos, part.Photo[len(part.Photo)-1].FileID)
+		}
+	}
+	return &message, photos
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"openrouter-bot/lang"
+	"strconv"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Префиксы callback data кнопок под ответом
+const (
+	CallbackRegenerate  = "rg"
+	CallbackContinue    = "ct"
+	CallbackRetryModels = "rm"
+	CallbackRetryWith   = "rw"
+	CallbackAnswerBack  = "rb"
+)
+
+// ContinuePrompt просит модель продолжить ответ, оборванный лимитом токенов
+const ContinuePrompt = "Continue your previous answer exactly where it stopped, without repeating what you already wrote."
+
+// AnswerKeyboard returns the buttons shown under an answer. Continue is offered
+// only for truncated answers, model switching only if there are models to choose from.
+func AnswerKeyboard(truncated, switchModel bool, language string) tgbotapi.InlineKeyboardMarkup {
+	flag := truncatedFlag(truncated)
+	row := []tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardButtonData(lang.Translate("buttons.regenerate", language), callbackData(CallbackRegenerate)),
+	}
+	if truncated {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(lang.Translate("buttons.continue", language), callbackData(CallbackContinue)))
+	}
+	if switchModel {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(lang.Translate("buttons.switchModel", language), callbackData(CallbackRetryModels, flag)))
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(row)
+}
+
+// RetryModelsKeyboard returns the list of models to retry the answer with.
+// Models are referenced by index, their IDs may not fit into callback data.
+func RetryModelsKeyboard(models []string, current string, truncated bool, language string) tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i, model := range models {
+		label := model
+		if model == current {
+			label = "✅ " + model
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(label, callbackData(CallbackRetryWith, strconv.Itoa(i))),
+		))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(lang.Translate("buttons.back", language), callbackData(CallbackAnswerBack, truncatedFlag(truncated))),
+	))
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+func truncatedFlag(truncated bool) string {
+	if truncated {
+		return "1"
+	}
+	return "0"
+}
@@ -0,0 +1,259 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type Model struct {
+	ID            string       `json:"id"`
+	Name          string       `json:"name"`
+	Description   string       `json:"description"`
+	ContextLength int          `json:"context_length"`
+	Pricing       ModelPricing `json:"pricing"`
+	Architecture  struct {
+		InputModalities  []string `json:"input_modalities"`
+		OutputModalities []string `json:"output_modalities"`
+	} `json:"architecture"`
+	TopProvider struct {
+		MaxCompletionTokens int `json:"max_completion_tokens"`
+	} `json:"top_provider"`
+	SupportedParameters []string `json:"supported_parameters"`
+}
+
+// ModelPricing holds prices in USD per token (per image/request for Image and Request)
+type ModelPricing struct {
+	Prompt     string `json:"prompt"`
+	Completion string `json:"completion"`
+	Image      string `json:"image"`
+	Request    string `json:"request"`
+}
+
+type APIResponse struct {
+	Data []Model `json:"data"`
+}
+
+// IsFree reports whether the model is free to use
+func (m Model) IsFree() bool {
+	return parsePrice(m.Pricing.Prompt) == 0 && parsePrice(m.Pricing.Completion) == 0
+}
+
+// HasVision reports whether the model accepts images
+func (m Model) HasVision() bool {
+	return slices.Contains(m.Architecture.InputModalities, "image")
+}
+
+// OutputsImages reports whether the model can return images
+func (m Model) OutputsImages() bool {
+	return slices.Contains(m.Architecture.OutputModalities, "image")
+}
+
+// SupportsParameter reports whether the model accepts the sampling parameter
+func (m Model) SupportsParameter(name string) bool {
+	return slices.Contains(m.SupportedParameters, name)
+}
+
+// ModelCatalog is a cached list of models from the /models endpoint,
+// refreshed in the background.
+type ModelCatalog struct {
+	baseURL  string
+	apiKey   string
+	interval time.Duration
+	client   *http.Client
+
+	mu        sync.RWMutex
+	models    []Model
+	index     map[string]Model
+	updated   time.Time
+	attempted time.Time // последняя загрузка из ensureLoaded
+}
+
+// Пока каталог пуст, загрузка по требованию повторяется не чаще этого интервала
+const catalogRetryInterval = time.Minute
+
+// NewModelCatalog creates a catalog for the API at baseURL refreshed every interval
+func NewModelCatalog(baseURL, apiKey string, interval time.Duration) *ModelCatalog {
+	return &ModelCatalog{
+		baseURL:  baseURL,
+		apiKey:   apiKey,
+		interval: interval,
+		client:   &http.Client{Timeout: 30 * time.Second},
+		index:    make(map[string]Model),
+	}
+}
+
+// Run fetches the catalog and keeps refreshing it until ctx is done
+func (c *ModelCatalog) Run(ctx context.Context) {
+	if err := c.Refresh(ctx); err != nil {
+		log.Printf("Error loading model catalog: %v", err)
+	}
+	if c.interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.Refresh(ctx); err != nil {
+				log.Printf("Error refreshing model catalog: %v", err)
+			}
+		}
+	}
+}
+
+// Refresh reloads the list of models
+func (c *ModelCatalog) Refresh(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+"/models", nil)
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	if c.apiKey != "" {
+		req.Header.Add("Authorization", "Bearer "+c.apiKey)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error get models: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error get models: %s", resp.Status)
+	}
+
+	var apiResponse APIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&apiResponse); err != nil {
+		return fmt.Errorf("error parse json: %w", err)
+	}
+
+	index := make(map[string]Model, len(apiResponse.Data))
+	for _, model := range apiResponse.Data {
+		index[model.ID] = model
+	}
+
+	c.mu.Lock()
+	c.models = apiResponse.Data
+	c.index = index
+	c.updated = time.Now()
+	c.mu.Unlock()
+
+	log.Printf("Model catalog loaded: %d models", len(apiResponse.Data))
+	return nil
+}
+
+// Models returns all models, loading the catalog if it has not been loaded yet
+func (c *ModelCatalog) Models() []Model {
+	c.ensureLoaded()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.models
+}
+
+// Loaded reports whether the catalog holds any models
+func (c *ModelCatalog) Loaded() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.models) > 0
+}
+
+// Get returns the model with the given id
+func (c *ModelCatalog) Get(id string) (Model, bool) {
+	c.ensureLoaded()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	model, ok := c.index[id]
+	return model, ok
+}
+
+// Has reports whether the catalog knows the model
+func (c *ModelCatalog) Has(id string) bool {
+	_, ok := c.Get(id)
+	return ok
+}
+
+// Filter returns the models matching filter (see FilterAll and others)
+func (c *ModelCatalog) Filter(filter string) []Model {
+	return FilterModels(c.Models(), filter)
+}
+
+// ContextLength returns the context length of the model or 0 if unknown
+func (c *ModelCatalog) ContextLength(id string) int {
+	model, _ := c.Get(id)
+	return model.ContextLength
+}
+
+// EstimateCost returns the approximate cost in USD of a generation
+func (c *ModelCatalog) EstimateCost(id string, promptTokens, completionTokens, images int) (float64, bool) {
+	model, ok := c.Get(id)
+	if !ok {
+		return 0, false
+	}
+	cost := float64(promptTokens)*parsePrice(model.Pricing.Prompt) +
+		float64(completionTokens)*parsePrice(model.Pricing.Completion) +
+		float64(images)*parsePrice(model.Pricing.Image) +
+		parsePrice(model.Pricing.Request)
+	return cost, true
+}
+
+// ensureLoaded loads the catalog on first use if the background refresh has not
+// done it yet. After a failure it waits catalogRetryInterval before trying again.
+func (c *ModelCatalog) ensureLoaded() {
+	if c.Loaded() {
+		return
+	}
+	// Без паузы недоступный /models блокировал бы каждое обращение к каталогу
+	c.mu.Lock()
+	if time.Since(c.attempted) < catalogRetryInterval {
+		c.mu.Unlock()
+		return
+	}
+	c.attempted = time.Now()
+	c.mu.Unlock()
+
+	if err := c.Refresh(context.Background()); err != nil {
+		log.Printf("Error loading model catalog: %v", err)
+	}
+}
+
+// FilterModels returns the models matching filter
+func FilterModels(models []Model, filter string) []Model {
+	var result []Model
+	for _, model := range models {
+		switch filter {
+		case FilterFree:
+			if !model.IsFree() {
+				continue
+			}
+		case FilterPaid:
+			if model.IsFree() {
+				continue
+			}
+		case FilterVision:
+			if !model.HasVision() {
+				continue
+			}
+		}
+		result = append(result, model)
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result
+}
+
+func parsePrice(price string) float64 {
+	value, err := strconv.ParseFloat(price, 64)
+	if err != nil || value < 0 {
+		return 0
+	}
+	return value
+}
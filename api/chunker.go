@@ -0,0 +1,186 @@
+package api
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+const codeFence = "```"
+
+// chunker packs pieces of text into chunks no longer than limit UTF-16 units
+type chunker struct {
+	limit  int
+	chunks []string
+	cur    string
+}
+
+// SplitMessage splits text into chunks of at most limit UTF-16 code units, in
+// which Telegram counts message length. It prefers paragraph and line
+// boundaries and keeps code blocks balanced: a code block cut between chunks is
+// closed at the end of one chunk and re-opened with the same language at the
+// start of the next one.
+func SplitMessage(text string, limit int) []string {
+	c := &chunker{limit: limit}
+
+	var paragraph []string
+	flushParagraph := func() {
+		if len(paragraph) > 0 {
+			c.addParagraph(paragraph)
+			paragraph = nil
+		}
+	}
+
+	lines := strings.Split(text, "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+
+		if strings.HasPrefix(trimmed, codeFence) {
+			flushParagraph()
+			opener := trimmed
+			var body []string
+			for i++; i < len(lines); i++ {
+				if strings.TrimSpace(lines[i]) == codeFence {
+					break
+				}
+				body = append(body, lines[i])
+			}
+			// Незакрытый блок кода (например, ответ обрезан по MAX_TOKENS) закрываем сами
+			c.addCodeBlock(opener, body)
+			continue
+		}
+
+		if trimmed == "" {
+			flushParagraph()
+			continue
+		}
+		paragraph = append(paragraph, line)
+	}
+	flushParagraph()
+	c.flush()
+
+	return c.chunks
+}
+
+func (c *chunker) add(piece, sep string) {
+	if c.cur == "" {
+		c.cur = piece
+		return
+	}
+	if utf16Len(c.cur)+utf16Len(sep)+utf16Len(piece) <= c.limit {
+		c.cur += sep + piece
+		return
+	}
+	c.flush()
+	c.cur = piece
+}
+
+func (c *chunker) flush() {
+	if strings.TrimSpace(c.cur) != "" {
+		c.chunks = append(c.chunks, c.cur)
+	}
+	c.cur = ""
+}
+
+func (c *chunker) addParagraph(lines []string) {
+	text := strings.Join(lines, "\n")
+	if utf16Len(text) <= c.limit {
+		c.add(text, "\n\n")
+		return
+	}
+
+	sep := "\n\n"
+	for _, line := range lines {
+		for j, part := range splitLongLine(line, c.limit) {
+			if j > 0 {
+				sep = ""
+			}
+			c.add(part, sep)
+		}
+		sep = "\n"
+	}
+}
+
+func (c *chunker) addCodeBlock(opener string, body []string) {
+	block := opener + "\n" + strings.Join(body, "\n") + "\n" + codeFence
+	if len(body) == 0 {
+		block = opener + "\n" + codeFence
+	}
+	if utf16Len(block) <= c.limit {
+		c.add(block, "\n\n")
+		return
+	}
+
+	// Место под строку открытия, закрывающий ``` и переводы строк
+	capacity := c.limit - utf16Len(opener) - utf16Len(codeFence) - 2
+	if capacity < 1 {
+		capacity = c.limit
+	}
+
+	var group []string
+	groupLen := 0
+	emit := func() {
+		if len(group) == 0 {
+			return
+		}
+		c.add(opener+"\n"+strings.Join(group, "\n")+"\n"+codeFence, "\n\n")
+		group = nil
+		groupLen = 0
+	}
+	for _, line := range body {
+		for _, part := range splitLongLine(line, capacity) {
+			partLen := utf16Len(part)
+			if len(group) > 0 && groupLen+1+partLen > capacity {
+				emit()
+			}
+			if len(group) > 0 {
+				groupLen++
+			}
+			group = append(group, part)
+			groupLen += partLen
+		}
+	}
+	emit()
+}
+
+// splitLongLine cuts a line longer than limit UTF-16 units, preferring whitespace
+func splitLongLine(line string, limit int) []string {
+	runes := []rune(line)
+	var parts []string
+	for {
+		cut, units := 0, 0
+		for cut < len(runes) && units+utf16.RuneLen(runes[cut]) <= limit {
+			units += utf16.RuneLen(runes[cut])
+			cut++
+		}
+		if cut == len(runes) {
+			break
+		}
+		cut = max(cut, 1)
+		for i := cut - 1; i > cut/2; i-- {
+			if unicode.IsSpace(runes[i]) {
+				cut = i + 1
+				break
+			}
+		}
+		parts = append(parts, string(runes[:cut]))
+		runes = runes[cut:]
+	}
+	return append(parts, string(runes))
+}
+
+func runeLen(s string) int {
+	return utf8.RuneCountInString(s)
+}
+
+// utf16Len returns the length of s in UTF-16 code units, in which Telegram
+// counts the message length limits
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n += utf16.RuneLen(r)
+	}
+	return n
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitMessage(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    string
+		limit int
+		want  []string
+	}{
+		{"short", "hello", 100, []string{"hello"}},
+		{"empty", "", 100, nil},
+		{"paragraphs packed", "aaa\n\nbbb", 100, []string{"aaa\n\nbbb"}},
+		{"split on paragraph", "aaaa\n\nbbbb", 6, []string{"aaaa", "bbbb"}},
+		{"split on line", "aaaa\nbbbb", 6, []string{"aaaa", "bbbb"}},
+		{"long line on space", "aaaa bbbb", 6, []string{"aaaa ", "bbbb"}},
+		{"long word", "abcdefgh", 3, []string{"abc", "def", "gh"}},
+		{"emoji counted in UTF-16 units", "😀😀😀", 4, []string{"😀😀", "😀"}},
+		{"emoji line split on space", "😀😀 😀😀", 6, []string{"😀😀 ", "😀😀"}},
+		{"extra blank lines", "a\n\n\n\nb", 100, []string{"a\n\nb"}},
+		{"code block kept whole", "text\n\n```go\nx := 1\n```", 100, []string{"text\n\n```go\nx := 1\n```"}},
+		{"unterminated fence closed", "```py\nprint(1)", 100, []string{"```py\nprint(1)\n```"}},
+		{"empty code block", "```\n```", 100, []string{"```\n```"}},
+		{
+			"fence split across chunks",
+			"```go\nline1\nline2\nline3\n```",
+			21,
+			[]string{"```go\nline1\nline2\n```", "```go\nline3\n```"},
+		},
+		{
+			"text around split fence",
+			"intro\n\n```\naaaaaaaa\nbbbbbbbb\n```\n\noutro",
+			20,
+			[]string{"intro", "```\naaaaaaaa\n```", "```\nbbbbbbbb\n```", "outro"},
+		},
+		{
+			"table stays as lines",
+			"| a | b |\n|---|---|\n| 1 | 2 |",
+			100,
+			[]string{"| a | b |\n|---|---|\n| 1 | 2 |"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SplitMessage(tt.in, tt.limit)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SplitMessage(%q, %d)\n got: %q\nwant: %q", tt.in, tt.limit, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitMessageLimitAndFences(t *testing.T) {
+	var b strings.Builder
+	b.WriteString("Intro paragraph.\n\n```python\n")
+	for i := 0; i < 200; i++ {
+		b.WriteString("print('line number ', " + strings.Repeat("x", i%30) + ")\n")
+	}
+	b.WriteString("```\n\nAfter the code.\n\n```\nunterminated")
+
+	const limit = 500
+	chunks := SplitMessage(b.String(), limit)
+	if len(chunks) < 2 {
+		t.Fatalf("expected several chunks, got %d", len(chunks))
+	}
+	for i, chunk := range chunks {
+		if n := utf16Len(chunk); n > limit {
+			t.Errorf("chunk %d has %d UTF-16 units, limit %d", i, n, limit)
+		}
+		if fences := strings.Count(chunk, codeFence); fences%2 != 0 {
+			t.Errorf("chunk %d has unbalanced code fences:\n%s", i, chunk)
+		}
+		if strings.Contains(chunk, "print(") && !strings.HasPrefix(strings.TrimSpace(chunk[strings.Index(chunk, codeFence):]), "```python") {
+			t.Errorf("chunk %d does not re-open the code block with its language:\n%s", i, chunk)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"openrouter-bot/config"
+	"openrouter-bot/user"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/ledongthuc/pdf"
+)
+
+const (
+	// Текст файла в запросе к модели: имя, содержимое и вопрос пользователя
+	documentTemplate = "<file name=\"%s\">\n%s\n</file>\n\n%s"
+	// Пометка об обрезанном тексте файла
+	documentTruncated = "\n[... the rest of the file is truncated]"
+)
+
+// ErrUnsupportedDocument — из файла нельзя извлечь текст
+var ErrUnsupportedDocument = errors.New("unsupported document type")
+
+// MIME-типы текстовых форматов, которые не начинаются с text/
+var textMimeTypes = map[string]bool{
+	"application/json":       true,
+	"application/xml":        true,
+	"application/x-yaml":     true,
+	"application/yaml":       true,
+	"application/javascript": true,
+	"application/x-sh":       true,
+	"application/sql":        true,
+	"application/toml":       true,
+	"application/x-ndjson":   true,
+}
+
+// DocumentPrompt downloads the document attached to the message and builds the
+// prompt from its text, truncated to the token budget, and the caption. Without
+// a caption DOCUMENT_PROMPT is used.
+func DocumentPrompt(ctx context.Context, bot *tgbotapi.BotAPI, conf *config.Config, catalog *ModelCatalog, model string, message *tgbotapi.Message, caption string) (string, error) {
+	doc := message.Document
+	data, err := downloadFile(ctx, bot, doc.FileID, min(conf.DocumentMaxSize, telegramFileLimit))
+	if err != nil {
+		return "", err
+	}
+	text, err := extractText(doc.FileName, doc.MimeType, data)
+	if err != nil {
+		return "", err
+	}
+
+	// Файл не должен вытеснить из контекста всю историю
+	budget := conf.DocumentMaxTokens
+	if contextLength := catalog.ContextLength(model); contextLength > 0 {
+		budget = min(budget, contextLength/2)
+	}
+	if caption == "" {
+		caption = conf.DocumentPrompt
+	}
+	return fmt.Sprintf(documentTemplate, doc.FileName, truncateTokens(text, budget), caption), nil
+}
+
+// extractText извлекает текст из PDF и текстовых файлов: обычного текста, Markdown, CSV, кода
+func extractText(name, mimeType string, data []byte) (string, error) {
+	if mimeType == "application/pdf" || strings.EqualFold(filepath.Ext(name), ".pdf") {
+		return extractPDFText(data)
+	}
+	// Для кода Telegram часто присылает application/octet-stream, поэтому
+	// любой файл в UTF-8 без нулевых байтов считается текстовым
+	textual := strings.HasPrefix(mimeType, "text/") || textMimeTypes[mimeType]
+	if !textual && (!utf8.Valid(data) || bytes.IndexByte(data, 0) >= 0) {
+		return "", ErrUnsupportedDocument
+	}
+	return strings.ToValidUTF8(string(data), "�"), nil
+}
+
+func extractPDFText(data []byte) (text string, err error) {
+	// Разбор повреждённых PDF может паниковать
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("read pdf: %v", r)
+		}
+	}()
+
+	reader, err := pdf.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", fmt.Errorf("read pdf: %w", err)
+	}
+	plain, err := reader.GetPlainText()
+	if err != nil {
+		return "", fmt.Errorf("read pdf: %w", err)
+	}
+	content, err := io.ReadAll(plain)
+	if err != nil {
+		return "", fmt.Errorf("read pdf: %w", err)
+	}
+	if strings.TrimSpace(string(content)) == "" {
+		// Скан без текстового слоя
+		return "", ErrUnsupportedDocument
+	}
+	return string(content), nil
+}
+
+// truncateTokens обрезает текст примерно до maxTokens токенов по оценке user.EstimateTokens
+func truncateTokens(text string, maxTokens int) string {
+	tokens := user.EstimateTokens(text)
+	if tokens <= maxTokens {
+		return text
+	}
+	runes := []rune(text)
+	return string(runes[:len(runes)*maxTokens/tokens]) + documentTruncated
+}
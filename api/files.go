@@ -0,0 +1,65 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	// Максимальный размер файла, который бот может скачать через Bot API
+	telegramFileLimit = 20 << 20
+	// Время на скачивание файла до 20 МБ
+	fileDownloadTimeout = 2 * time.Minute
+)
+
+// fileClient ограничивает время скачивания, чтобы зависшее соединение не держало запрос до /stop
+var fileClient = &http.Client{Timeout: fileDownloadTimeout}
+
+// ErrFileTooLarge — файл больше допустимого размера
+var ErrFileTooLarge = errors.New("file is too large")
+
+// downloadFile скачивает файл Telegram размером не больше maxSize байт.
+// Ссылка на файл содержит токен бота, поэтому она не выходит за пределы
+// этой функции и не попадает в ошибки.
+func downloadFile(ctx context.Context, bot *tgbotapi.BotAPI, fileID string, maxSize int) ([]byte, error) {
+	file, err := bot.GetFile(tgbotapi.FileConfig{FileID: fileID})
+	if err != nil {
+		return nil, fmt.Errorf("get file: %w", err)
+	}
+	if file.FileSize > maxSize {
+		return nil, ErrFileTooLarge
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, file.Link(bot.Token), nil)
+	if err != nil {
+		return nil, errors.New("download file: invalid request")
+	}
+	resp, err := fileClient.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, fmt.Errorf("download file: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download file: %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, int64(maxSize)+1))
+	if err != nil {
+		return nil, fmt.Errorf("download file: %w", err)
+	}
+	if len(data) > maxSize {
+		return nil, ErrFileTooLarge
+	}
+	return data, nil
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"sort"
+	"strings"
+)
+
+// Suggest returns up to n models whose IDs are closest to query: models
+// containing the query come first, the rest are ordered by edit distance.
+func (c *ModelCatalog) Suggest(query string, n int) []Model {
+	query = strings.ToLower(strings.TrimSpace(query))
+	if query == "" {
+		return nil
+	}
+
+	type candidate struct {
+		model    Model
+		contains bool
+		distance int
+	}
+	threshold := max(3, len(query)/2)
+
+	var candidates []candidate
+	for _, model := range c.Models() {
+		id := strings.ToLower(model.ID)
+		name := id
+		if i := strings.LastIndex(id, "/"); i >= 0 {
+			name = id[i+1:]
+		}
+
+		contains := strings.Contains(id, query) || strings.Contains(query, name)
+		distance := min(levenshtein(query, id), levenshtein(query, name))
+		if !contains && distance > threshold {
+			continue
+		}
+		candidates = append(candidates, candidate{model: model, contains: contains, distance: distance})
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].contains != candidates[j].contains {
+			return candidates[i].contains
+		}
+		if candidates[i].distance != candidates[j].distance {
+			return candidates[i].distance < candidates[j].distance
+		}
+		return candidates[i].model.ID < candidates[j].model.ID
+	})
+
+	result := make([]Model, 0, n)
+	for i := 0; i < len(candidates) && i < n; i++ {
+		result = append(result, candidates[i].model)
+	}
+	return result
+}
+
+// levenshtein returns the edit distance between a and b
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
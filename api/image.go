@@ -0,0 +1,248 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"openrouter-bot/config"
+	"openrouter-bot/lang"
+	"openrouter-bot/user"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/sashabaranov/go-openai"
+)
+
+const (
+	// Максимальная длина подписи к фото в Telegram
+	telegramCaptionLimit = 1024
+	// Содержимое ответа в истории, если модель вернула только изображения
+	imageHistoryText = "[generated %d image(s)]"
+	// Генерация изображения идёт заметно дольше обычного ответа
+	imageRequestTimeout = 3 * time.Minute
+)
+
+// imageClient ограничивает время запроса, чтобы зависший сервер не держал его до /stop
+var imageClient = &http.Client{Timeout: imageRequestTimeout}
+
+// Изображения в тексте ответа: Markdown-картинки и голые data URL
+var dataURLRe = regexp.MustCompile(`!\[[^\]]*\]\((data:image/[\w.+-]+;base64,[A-Za-z0-9+/=\s]+)\)|data:image/[\w.+-]+;base64,[A-Za-z0-9+/=]+`)
+
+// imageRequest — поля запроса к /chat/completions сверх openai.ChatCompletionRequest,
+// в том числе modalities, которого нет в go-openai
+type imageRequest struct {
+	OpenRouterParams
+	Modalities []string `json:"modalities"`
+}
+
+// imageResponse — ответ с изображениями в choices[].message.images (формат OpenRouter)
+type imageResponse struct {
+	ID      string `json:"id"`
+	Choices []struct {
+		Message struct {
+			Content string `json:"content"`
+			Images  []struct {
+				ImageURL struct {
+					URL string `json:"url"`
+				} `json:"image_url"`
+			} `json:"images"`
+		} `json:"message"`
+	} `json:"choices"`
+	Usage *openai.Usage `json:"usage"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// OutputsImages reports whether the catalog knows the model as able to return images.
+func (c *ModelCatalog) OutputsImages(id string) bool {
+	model, ok := c.Get(id)
+	return ok && model.OutputsImages()
+}
+
+// generateImages отправляет запрос без стрима с modalities image и text,
+// потому что изображения не приходят в дельтах go-openai
+func generateImages(ctx context.Context, conf *config.Config, req openai.ChatCompletionRequest, extra OpenRouterParams) (string, []string, string, *openai.Usage, error) {
+	req.Stream = false
+	req.StreamOptions = nil
+	body, err := json.Marshal(req)
+	if err != nil {
+		return "", nil, "", nil, err
+	}
+	body, err = mergeJSON(body, imageRequest{OpenRouterParams: extra, Modalities: []string{"image", "text"}})
+	if err != nil {
+		return "", nil, "", nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(conf.OpenAIBaseURL, "/")+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return "", nil, "", nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+conf.OpenAIApiKey)
+
+	resp, err := imageClient.Do(httpReq)
+	if err != nil {
+		return "", nil, "", nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", nil, "", nil, err
+	}
+
+	var result imageResponse
+	if err := json.Unmarshal(data, &result); err != nil {
+		return "", nil, "", nil, fmt.Errorf("image response (%s): %w", resp.Status, err)
+	}
+	if result.Error != nil {
+		return "", nil, result.ID, result.Usage, errors.New(result.Error.Message)
+	}
+	if resp.StatusCode != http.StatusOK || len(result.Choices) == 0 {
+		return "", nil, result.ID, result.Usage, fmt.Errorf("image response: %s", resp.Status)
+	}
+
+	message := result.Choices[0].Message
+	var urls []string
+	for _, image := range message.Images {
+		urls = append(urls, image.ImageURL.URL)
+	}
+	return message.Content, urls, result.ID, result.Usage, nil
+}
+
+// extractImages вырезает из текста ответа изображения в виде data URL
+func extractImages(text string) (string, []string) {
+	var urls []string
+	text = dataURLRe.ReplaceAllStringFunc(text, func(match string) string {
+		if sub := dataURLRe.FindStringSubmatch(match); sub[1] != "" {
+			match = sub[1]
+		}
+		urls = append(urls, strings.Join(strings.Fields(match), ""))
+		return ""
+	})
+	return strings.TrimSpace(text), urls
+}
+
+// imageFile превращает data URL или ссылку на изображение в файл для отправки в Telegram
+func imageFile(url string, index int) (tgbotapi.RequestFileData, error) {
+	if !strings.HasPrefix(url, "data:") {
+		return tgbotapi.FileURL(url), nil
+	}
+	header, payload, found := strings.Cut(url, ",")
+	if !found || !strings.HasSuffix(header, ";base64") {
+		return nil, errors.New("unsupported data URL")
+	}
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, fmt.Errorf("decode image: %w", err)
+	}
+	ext := strings.TrimPrefix(strings.TrimSuffix(header, ";base64"), "data:image/")
+	return tgbotapi.FileBytes{Name: "image" + strconv.Itoa(index+1) + "." + ext, Bytes: data}, nil
+}
+
+// sendImages отправляет изображения ответом на сообщение пользователя: одно —
+// фото, несколько — альбомом. Короткий текст ответа становится подписью,
+// длинный отправляется отдельно. Возвращает ID отправленных сообщений.
+func sendImages(bot *tgbotapi.BotAPI, message *tgbotapi.Message, placeholderID int, urls []string, text string, keyboard tgbotapi.InlineKeyboardMarkup, config *config.Config, language string) []int {
+	var files []tgbotapi.RequestFileData
+	for i, url := range urls {
+		file, err := imageFile(url, i)
+		if err != nil {
+			log.Printf("Error preparing image: %v", err)
+			continue
+		}
+		files = append(files, file)
+	}
+	if len(files) == 0 {
+		if text == "" {
+			text = lang.Translate("errorText", language)
+		}
+		return sendAnswer(bot, message, placeholderID, text, keyboard, config, language)
+	}
+
+	caption := ""
+	if utf16Len(text) <= telegramCaptionLimit {
+		caption = text
+	}
+
+	var messageIDs []int
+	if len(files) == 1 {
+		photo := tgbotapi.NewPhoto(message.Chat.ID, files[0])
+		photo.Caption = caption
+		photo.ReplyToMessageID = message.MessageID
+		if caption == text {
+			photo.ReplyMarkup = keyboard
+		}
+		if sent, err := bot.Send(photo); err != nil {
+			log.Printf("Error sending image: %v", err)
+		} else {
+			messageIDs = append(messageIDs, sent.MessageID)
+		}
+	} else {
+		// Текст к альбому отправляется отдельным сообщением вместе с кнопками
+		media := make([]interface{}, 0, len(files))
+		for _, file := range files {
+			media = append(media, tgbotapi.NewInputMediaPhoto(file))
+		}
+		group := tgbotapi.NewMediaGroup(message.Chat.ID, media)
+		group.ReplyToMessageID = message.MessageID
+		sent, err := bot.SendMediaGroup(group)
+		if err != nil {
+			log.Printf("Error sending images: %v", err)
+		}
+		for _, msg := range sent {
+			messageIDs = append(messageIDs, msg.MessageID)
+		}
+	}
+	if len(messageIDs) == 0 {
+		bot.Send(tgbotapi.NewEditMessageText(message.Chat.ID, placeholderID, lang.Translate("errorText", language)))
+		return nil
+	}
+
+	// Заглушка больше не нужна: длинный текст заменяет её, иначе она удаляется.
+	// К альбому нельзя прикрепить кнопки, поэтому они остаются под текстом.
+	if caption != text || len(files) > 1 {
+		if text == "" {
+			text = lang.Translate("image.done", language)
+		}
+		return append(messageIDs, sendAnswer(bot, message, placeholderID, text, keyboard, config, language)...)
+	}
+	if _, err := bot.Request(tgbotapi.NewDeleteMessage(message.Chat.ID, placeholderID)); err != nil {
+		log.Printf("Error deleting placeholder: %v", err)
+	}
+	return messageIDs
+}
+
+// imageAnswer получает от модели ответ с изображениями и отправляет его пользователю
+func imageAnswer(ctx context.Context, bot *tgbotapi.BotAPI, req openai.ChatCompletionRequest, extra OpenRouterParams, message *tgbotapi.Message, photos []string, placeholderID, rewindID int, config *config.Config, u *user.UsageTracker, language string) (string, *openai.Usage) {
+	text, urls, responseID, usage, err := generateImages(ctx, config, req, extra)
+	if err != nil {
+		log.Printf("Image generation error: %v", err)
+		errorText := lang.Translate("errorText", language)
+		if ctx.Err() != nil {
+			errorText = lang.Translate("commands.stop", language)
+		}
+		bot.Send(tgbotapi.NewEditMessageText(message.Chat.ID, placeholderID, errorText))
+		return responseID, usage
+	}
+	text, inline := extractImages(text)
+	urls = append(urls, inline...)
+
+	keyboard := AnswerKeyboard(false, len(config.AllowedModels) > 0, language)
+	messageIDs := sendImages(bot, message, placeholderID, urls, text, keyboard, config, language)
+
+	content := text
+	if content == "" {
+		content = fmt.Sprintf(imageHistoryText, len(urls))
+	}
+	saveTurn(u, message, photos, content, messageIDs, rewindID)
+	return responseID, usage
+}
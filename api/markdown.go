@@ -0,0 +1,220 @@
+package api
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	headingRe    = regexp.MustCompile(`^#{1,6}\s+(.*?)(?:\s+#+)?\s*$`)
+	bulletRe     = regexp.MustCompile(`^(\s*)[-*+]\s+(.*)$`)
+	numberedRe   = regexp.MustCompile(`^(\s*)(\d+[.)])\s+(.*)$`)
+	ruleRe       = regexp.MustCompile(`^\s*(-\s*){3,}$|^\s*(\*\s*){3,}$|^\s*(_\s*){3,}$`)
+	tableSepRe   = regexp.MustCompile(`^\s*\|?\s*:?-+:?\s*(\|\s*:?-+:?\s*)*\|?\s*$`)
+	htmlReplacer = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	attrReplacer = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;")
+)
+
+// RenderHTML converts the CommonMark emitted by models into the HTML subset
+// supported by Telegram: code blocks with language, inline code, bold, italic,
+// strikethrough, links, headings, quotes and lists. Tables are rendered as
+// monospace blocks since Telegram has no table entity.
+func RenderHTML(md string) string {
+	lines := strings.Split(md, "\n")
+	out := make([]string, 0, len(lines))
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case strings.HasPrefix(trimmed, codeFence):
+			language := strings.TrimSpace(strings.TrimPrefix(trimmed, codeFence))
+			var body []string
+			for i++; i < len(lines); i++ {
+				if strings.TrimSpace(lines[i]) == codeFence {
+					break
+				}
+				body = append(body, lines[i])
+			}
+			code := htmlReplacer.Replace(strings.Join(body, "\n"))
+			if language != "" {
+				out = append(out, `<pre><code class="language-`+attrReplacer.Replace(language)+`">`+code+"</code></pre>")
+			} else {
+				out = append(out, "<pre>"+code+"</pre>")
+			}
+
+		case strings.HasPrefix(trimmed, "|") && i+1 < len(lines) && tableSepRe.MatchString(lines[i+1]):
+			var rows []string
+			for ; i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), "|"); i++ {
+				rows = append(rows, lines[i])
+			}
+			i--
+			out = append(out, "<pre>"+htmlReplacer.Replace(renderTable(rows))+"</pre>")
+
+		case strings.HasPrefix(trimmed, ">"):
+			var quote []string
+			for ; i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), ">"); i++ {
+				text := strings.TrimPrefix(strings.TrimSpace(lines[i]), ">")
+				quote = append(quote, renderInline(strings.TrimSpace(text)))
+			}
+			i--
+			out = append(out, "<blockquote>"+strings.Join(quote, "\n")+"</blockquote>")
+
+		case ruleRe.MatchString(line):
+			out = append(out, "──────────")
+
+		case headingRe.MatchString(line):
+			out = append(out, "<b>"+renderInline(headingRe.FindStringSubmatch(line)[1])+"</b>")
+
+		case bulletRe.MatchString(line):
+			m := bulletRe.FindStringSubmatch(line)
+			out = append(out, m[1]+"• "+renderInline(m[2]))
+
+		case numberedRe.MatchString(line):
+			m := numberedRe.FindStringSubmatch(line)
+			out = append(out, m[1]+m[2]+" "+renderInline(m[3]))
+
+		default:
+			out = append(out, renderInline(line))
+		}
+	}
+
+	return strings.Join(out, "\n")
+}
+
+// renderInline renders inline Markdown of a single line
+func renderInline(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		switch {
+		case s[i] == '`':
+			if end := strings.IndexByte(s[i+1:], '`'); end >= 0 {
+				b.WriteString("<code>" + htmlReplacer.Replace(s[i+1:i+1+end]) + "</code>")
+				i += end + 2
+				continue
+			}
+
+		case strings.HasPrefix(s[i:], "***") || strings.HasPrefix(s[i:], "___"):
+			delim := s[i : i+3]
+			if end := strings.Index(s[i+3:], delim); end > 0 {
+				b.WriteString("<b><i>" + renderInline(s[i+3:i+3+end]) + "</i></b>")
+				i += end + 6
+				continue
+			}
+			// Без пары *** разбирается как ** и *
+			fallthrough
+
+		case strings.HasPrefix(s[i:], "**") || strings.HasPrefix(s[i:], "__"):
+			delim := s[i : i+2]
+			if end := strings.Index(s[i+2:], delim); end > 0 {
+				b.WriteString("<b>" + renderInline(s[i+2:i+2+end]) + "</b>")
+				i += end + 4
+				continue
+			}
+
+		case strings.HasPrefix(s[i:], "~~"):
+			if end := strings.Index(s[i+2:], "~~"); end > 0 {
+				b.WriteString("<s>" + renderInline(s[i+2:i+2+end]) + "</s>")
+				i += end + 4
+				continue
+			}
+
+		case s[i] == '*' || s[i] == '_':
+			if end, ok := matchEmphasis(s, i); ok {
+				b.WriteString("<i>" + renderInline(s[i+1:end]) + "</i>")
+				i = end + 1
+				continue
+			}
+
+		case s[i] == '[':
+			if text, url, n, ok := matchLink(s[i:]); ok {
+				b.WriteString(`<a href="` + attrReplacer.Replace(url) + `">` + renderInline(text) + "</a>")
+				i += n
+				continue
+			}
+		}
+
+		b.WriteString(htmlReplacer.Replace(s[i : i+1]))
+		i++
+	}
+	return b.String()
+}
+
+// matchEmphasis finds the closing delimiter of *italic* or _italic_ started at i.
+// Underscores inside words (snake_case) are not treated as emphasis.
+func matchEmphasis(s string, i int) (int, bool) {
+	c := s[i]
+	if i+1 >= len(s) || s[i+1] == ' ' || s[i+1] == c {
+		return 0, false
+	}
+	if c == '_' && i > 0 && isWordByte(s[i-1]) {
+		return 0, false
+	}
+	for j := i + 2; j < len(s); j++ {
+		if s[j] != c || s[j-1] == ' ' {
+			continue
+		}
+		if c == '_' && j+1 < len(s) && isWordByte(s[j+1]) {
+			continue
+		}
+		return j, true
+	}
+	return 0, false
+}
+
+// matchLink parses [text](url) at the start of s
+func matchLink(s string) (text, url string, n int, ok bool) {
+	mid := strings.Index(s, "](")
+	if mid < 1 || strings.Contains(s[1:mid], "]") {
+		return "", "", 0, false
+	}
+	end := strings.IndexByte(s[mid+2:], ')')
+	if end < 1 {
+		return "", "", 0, false
+	}
+	url = s[mid+2 : mid+2+end]
+	if strings.ContainsAny(url, " \t") {
+		return "", "", 0, false
+	}
+	return s[1:mid], url, mid + 3 + end, true
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+// renderTable aligns the cells of a Markdown table into monospace columns
+func renderTable(rows []string) string {
+	var cells [][]string
+	var widths []int
+	for _, row := range rows {
+		if tableSepRe.MatchString(row) {
+			continue
+		}
+		row = strings.TrimSpace(row)
+		row = strings.TrimPrefix(row, "|")
+		row = strings.TrimSuffix(row, "|")
+		parts := strings.Split(row, "|")
+		for j := range parts {
+			parts[j] = strings.TrimSpace(parts[j])
+			if j >= len(widths) {
+				widths = append(widths, 0)
+			}
+			if l := runeLen(parts[j]); l > widths[j] {
+				widths[j] = l
+			}
+		}
+		cells = append(cells, parts)
+	}
+
+	lines := make([]string, 0, len(cells))
+	for _, row := range cells {
+		padded := make([]string, len(row))
+		for j, cell := range row {
+			padded[j] = cell + strings.Repeat(" ", widths[j]-runeLen(cell))
+		}
+		lines = append(lines, strings.TrimRight(strings.Join(padded, " | "), " "))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,45 @@
+package api
+
+import "testing"
+
+func TestRenderHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"heading", "# Title", "<b>Title</b>"},
+		{"heading closing sequence", "## Title ##", "<b>Title</b>"},
+		{"heading ending with hash", "# C#", "<b>C#</b>"},
+		{"heading with inline", "### Use `go test`", "<b>Use <code>go test</code></b>"},
+		{"not a heading", "#hashtag", "#hashtag"},
+		{"bold", "**bold** text", "<b>bold</b> text"},
+		{"bold italic", "***x***", "<b><i>x</i></b>"},
+		{"bold italic underscores", "___x___", "<b><i>x</i></b>"},
+		{"italic", "*it* and _it_", "<i>it</i> and <i>it</i>"},
+		{"snake_case", "call snake_case_name here", "call snake_case_name here"},
+		{"snake_case in italic", "_use snake_case_", "<i>use snake_case</i>"},
+		{"strikethrough", "~~old~~", "<s>old</s>"},
+		{"inline code escapes", "`a<b>&c`", "<code>a&lt;b&gt;&amp;c</code>"},
+		{"unclosed inline code", "a `b", "a `b"},
+		{"link", "[site](https://example.com?a=1&b=2)", `<a href="https://example.com?a=1&amp;b=2">site</a>`},
+		{"html escaped", "1 < 2 & 3 > 2", "1 &lt; 2 &amp; 3 &gt; 2"},
+		{"bullet", "- item\n  * nested", "• item\n  • nested"},
+		{"numbered", "1. one\n2) two", "1. one\n2) two"},
+		{"rule", "---", "──────────"},
+		{"quote", "> one\n> **two**", "<blockquote>one\n<b>two</b></blockquote>"},
+		{"code block with language", "```go\nx := a < b\n```", `<pre><code class="language-go">x := a &lt; b</code></pre>`},
+		{"code block keeps markdown", "```\n**not bold**\n```", "<pre>**not bold**</pre>"},
+		{"unterminated code block", "```python\nprint(1)", `<pre><code class="language-python">print(1)</code></pre>`},
+		{"table", "| a | bb |\n|---|---|\n| ccc | d |", "<pre>a   | bb\nccc | d</pre>"},
+		{"table escaped", "| <x> |\n|---|", "<pre>&lt;x&gt;</pre>"},
+		{"pipe without separator", "| not a table |", "| not a table |"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RenderHTML(tt.in); got != tt.want {
+				t.Errorf("RenderHTML(%q)\n got: %q\nwant: %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
@@ -2,82 +2,105 @@ package api
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
-	"net/http"
 	"openrouter-bot/config"
 	configs "openrouter-bot/config"
 	"openrouter-bot/lang"
 	"openrouter-bot/user"
 	"strings"
 	"time"
+	"unicode/utf16"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/sashabaranov/go-openai"
 )
 
-type Model struct {
-	ID          string `json:"id"`
-	Description string `json:"description"`
-	Pricing     struct {
-		Prompt string `json:"prompt"`
-	} `json:"pricing"`
-}
+const (
+	// Минимальный интервал между правками сообщения во время стрима
+	streamEditInterval = 1500 * time.Millisecond
+	// Максимальная длина текста сообщения в Telegram
+	telegramMessageLimit = 4096
+	// Длина части длинного ответа; лимит Telegram считается после разбора разметки,
+	// небольшой запас оставлен под выравнивание таблиц
+	telegramChunkLimit = 3800
+	// Доля контекста модели, которую разрешено занимать по оценке токенов
+	tokenBudgetPercent = 90
+	// Заголовок сообщения с кратким содержанием ранней части разговора
+	summaryPrefix = "Summary of the earlier conversation:\n"
+)
 
-type APIResponse struct {
-	Data []Model `json:"data"`
+// ChatRequest описывает сообщение пользователя, на которое отвечает модель
+type ChatRequest struct {
+	Message *tgbotapi.Message
+	Quote   string   // Фрагмент сообщения, процитированный в ответе на него
+	Author  string   // Имя автора, подписываемое к тексту в общей истории группы
+	Image   bool     // Генерация изображения по команде /image
+	Photos  []string // file_id фотографий альбома; одиночное фото берётся из Message
+	Rewind  bool     // Ответ на старый ответ бота откатывает к нему историю
+	// Сообщения прежнего ответа при повторной генерации: первое становится
+	// заглушкой нового ответа, остальные удаляются
+	AnswerIDs []int
 }
 
-func GetFreeModels() (string, error) {
-	manager, err := config.NewManager("./config.yaml")
-	if err != nil {
-		log.Fatalf("Error initializing config manager: %v", err)
+// PhotoIDs returns the Telegram file IDs of the images sent with the request.
+func (r ChatRequest) PhotoIDs() []string {
+	if len(r.Photos) > 0 {
+		return r.Photos
 	}
-	conf := manager.GetConfig()
-
-	resp, err := http.Get(conf.OpenAIBaseURL + "/models")
-	if err != nil {
-		return "", fmt.Errorf("error get models: %v", err)
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("error read response: %v", err)
-	}
-
-	var apiResponse APIResponse
-	err = json.Unmarshal(body, &apiResponse)
-	if err != nil {
-		return "", fmt.Errorf("error parse json: %v", err)
+	if photos := r.Message.Photo; len(photos) > 0 {
+		// Самый большой размер фото
+		return []string{photos[len(photos)-1].FileID}
 	}
+	return nil
+}
 
-	var result strings.Builder
-	for _, model := range apiResponse.Data {
-		// Filter by price
-		if model.Pricing.Prompt == "0" {
-			// escapedDesc := strings.ReplaceAll(model.Description, "*", "\\*")
-			// escapedDesc = strings.ReplaceAll(escapedDesc, "_", "\\_")
-			// result.WriteString(fmt.Sprintf("%s - %s\n", model.ID, escapedDesc))
-			result.WriteString(fmt.Sprintf("➡ `%s`\n", model.ID))
-			// result.WriteString(fmt.Sprintf("➡ `/set_model %s`\n", model.ID))
-		}
+// ModelFor returns the model that answers the request: IMAGE_MODEL for /image
+// if it is set, otherwise the model of the current conversation.
+func (r ChatRequest) ModelFor(conf *config.Config, u *user.UsageTracker) string {
+	if r.Image && conf.ImageModel != "" {
+		return conf.ImageModel
 	}
-	return result.String(), nil
+	return u.GetModel(conf)
 }
 
+// HandleChatGPTStreamResponse streams the answer to the request. History and
+// model settings come from user; extra requests such as history summaries are
+// charged to payer, who can differ from user in groups with shared history.
 func HandleChatGPTStreamResponse(
+	ctx context.Context,
 	bot *tgbotapi.BotAPI,
 	client *openai.Client,
-	message *tgbotapi.Message,
+	request ChatRequest,
 	config *config.Config,
-	user *user.UsageTracker,
-) string {
+	user, payer *user.UsageTracker,
+	catalog *ModelCatalog,
+) (string, *openai.Usage) {
+
+	message := request.Message
 
-	ctx := context.Background()
-	user.CheckHistory(config.MaxHistorySize, config.MaxHistoryTime)
+	model := request.ModelFor(config, user)
+	user.ExpireHistory(config.MaxHistoryTime)
+	photos := request.PhotoIDs()
+	text := message.Text
+	if text == "" {
+		text = message.Caption
+	}
+	if text == "" && len(photos) > 0 {
+		text = config.VisionPrompt
+	}
+	if request.Author != "" && text != "" {
+		text = request.Author + ": " + text
+	}
+	prompt, rewindID := replyContext(bot, message, request.Quote, user, request.Rewind)
+	text = prompt + text
+	if text != message.Text {
+		withContext := *message
+		withContext.Text = text
+		message = &withContext
+	}
 	user.LastMessageTime = time.Now()
 
 	err := lang.LoadTranslations("./lang/")
@@ -93,23 +116,49 @@ func HandleChatGPTStreamResponse(
 
 	errorMessage := lang.Translate("errorText", conf.Lang)
 
+	// Заглушка, которую будем редактировать по мере поступления ответа;
+	// отправляется до сжатия истории, которое занимает ещё один запрос к модели
+	sent, err := sendPlaceholder(bot, request, lang.Translate("loadText", conf.Lang))
+	if err != nil {
+		log.Printf("Error sending placeholder: %v", err)
+		return "", nil
+	}
+
+	// История до старого ответа уже помещалась в контекст, а после отката
+	// она сожмётся на следующем сообщении
+	history := user.GetMessages()
+	if rewindID != 0 {
+		if earlier, ok := user.MessagesUntil(message.Chat.ID, rewindID); ok {
+			history = earlier
+		}
+	} else {
+		compactHistory(ctx, client, config, user, payer, catalog, model, message.Text)
+		history = user.GetMessages()
+	}
+
 	// Формируем историю сообщений
 	messages := []openai.ChatCompletionMessage{
 		{
 			Role:    openai.ChatMessageRoleSystem,
-			Content: user.SystemPrompt,
+			Content: user.GetSystemPrompt(config),
 		},
 	}
 
-	for _, msg := range user.GetMessages() {
+	// Модели без поддержки изображений получают из истории только текст
+	vision := AcceptsImages(catalog, config, model)
+	for _, msg := range history {
+		if vision && len(msg.Images) > 0 {
+			messages = append(messages, visionMessage(ctx, bot, config, msg.Role, msg.Content, msg.Images))
+			continue
+		}
 		messages = append(messages, openai.ChatCompletionMessage{
 			Role:    msg.Role,
 			Content: msg.Content,
 		})
 	}
 
-	if config.Vision == "true" {
-		messages = append(messages, addVisionMessage(bot, message, config))
+	if vision && len(photos) > 0 {
+		messages = append(messages, visionMessage(ctx, bot, config, openai.ChatMessageRoleUser, message.Text, photos))
 	} else {
 		messages = append(messages, openai.ChatCompletionMessage{
 			Role:    openai.ChatMessageRoleUser,
@@ -117,84 +166,242 @@ func HandleChatGPTStreamResponse(
 		})
 	}
 
-	// Готовый нестримовый запрос
-	req := openai.ChatCompletionRequest{
-		Model:            config.Model.ModelName,
-		FrequencyPenalty: float32(config.Model.FrequencyPenalty),
-		PresencePenalty:  float32(config.Model.PresencePenalty),
-		Temperature:      float32(config.Model.Temperature),
-		TopP:             float32(config.Model.TopP),
-		MaxTokens:        config.MaxTokens,
-		Messages:         messages,
-		Stream:           false,
+	// Стримовый запрос; поля OpenRouter добавляет ParamsClient
+	req, extra := buildChatRequest(catalog, model, messages, user.GetMaxTokens(config), ModelParameters(config, user))
+	req.Stream = true
+	req.StreamOptions = &openai.StreamOptions{IncludeUsage: true}
+	ctx = withParams(ctx, extra)
+
+	// Изображения не приходят в стриме, для моделей с генерацией изображений нужен обычный запрос
+	if request.Image || catalog.OutputsImages(model) {
+		return imageAnswer(ctx, bot, req, extra, message, photos, sent.MessageID, rewindID, config, user, conf.Lang)
 	}
 
-	resp, err := client.CreateChatCompletion(ctx, req)
+	stream, err := client.CreateChatCompletionStream(ctx, req)
 	if err != nil {
-		log.Printf("ChatCompletion error: %v\n", err)
-		msg := tgbotapi.NewMessage(message.Chat.ID, errorMessage)
-		bot.Send(msg)
-		return ""
+		log.Printf("ChatCompletionStream error: %v\n", err)
+		text := errorMessage
+		if ctx.Err() != nil {
+			text = lang.Translate("commands.stop", conf.Lang)
+		}
+		bot.Send(tgbotapi.NewEditMessageText(message.Chat.ID, sent.MessageID, text))
+		return "", nil
 	}
+	defer stream.Close()
 
-	answer := resp.Choices[0].Message.Content
+	var (
+		answer       strings.Builder
+		responseID   string
+		finishReason openai.FinishReason
+		usage        *openai.Usage
+		lastText     string
+		lastEdit     time.Time
+	)
+	for {
+		response, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			// /stop отменяет контекст запроса
+			if ctx.Err() != nil {
+				log.Printf("Stream stopped by user %s", user.UserID)
+				break
+			}
+			log.Printf("Stream error: %v\n", err)
+			if answer.Len() == 0 {
+				bot.Send(tgbotapi.NewEditMessageText(message.Chat.ID, sent.MessageID, errorMessage))
+				return responseID, usage
+			}
+			break
+		}
+		if responseID == "" {
+			responseID = response.ID
+		}
+		if response.Usage != nil {
+			usage = response.Usage
+		}
+		if len(response.Choices) > 0 {
+			answer.WriteString(response.Choices[0].Delta.Content)
+			if reason := response.Choices[0].FinishReason; reason != "" {
+				finishReason = reason
+			}
+		}
 
-	// Записываем в историю
-	user.AddMessage(openai.ChatMessageRoleUser, message.Text)
-	user.AddMessage(openai.ChatMessageRoleAssistant, answer)
+		// Telegram ограничивает частоту редактирования сообщений
+		if time.Since(lastEdit) < streamEditInterval {
+			continue
+		}
+		text := truncateText(answer.String(), telegramMessageLimit)
+		if strings.TrimSpace(text) == "" || text == lastText {
+			continue
+		}
+		if _, err := bot.Send(tgbotapi.NewEditMessageText(message.Chat.ID, sent.MessageID, text)); err != nil {
+			log.Printf("Error editing message: %v", err)
+		}
+		lastText = text
+		lastEdit = time.Now()
+	}
+
+	// Usage приходит последним чанком стрима; для остановленного ответа он оценивается по тексту
+	if usage == nil && ctx.Err() != nil {
+		usage = estimateUsage(messages, answer.String())
+	}
+
+	// Изображения в тексте ответа (data URL) отправляются фотографиями
+	result, images := extractImages(answer.String())
+	if result == "" && len(images) == 0 {
+		text := errorMessage
+		if ctx.Err() != nil {
+			text = lang.Translate("commands.stop", conf.Lang)
+		}
+		bot.Send(tgbotapi.NewEditMessageText(message.Chat.ID, sent.MessageID, text))
+		return responseID, usage
+	}
 
-	// Отправляем ответ одним сообщением
-	safe := tgbotapi.EscapeText(tgbotapi.ModeMarkdown, answer)
-	msg := tgbotapi.NewMessage(message.Chat.ID, safe)
-	msg.ParseMode = tgbotapi.ModeMarkdown
-	bot.Send(msg)
-	
-	return resp.ID
+	// Кнопки под ответом; «Продолжить» — если ответ оборван лимитом MAX_TOKENS
+	keyboard := AnswerKeyboard(finishReason == openai.FinishReasonLength, len(config.AllowedModels) > 0, conf.Lang)
+	var messageIDs []int
+	if len(images) > 0 {
+		messageIDs = sendImages(bot, message, sent.MessageID, images, result, keyboard, config, conf.Lang)
+		if result == "" {
+			result = fmt.Sprintf(imageHistoryText, len(images))
+		}
+	} else {
+		messageIDs = sendAnswer(bot, message, sent.MessageID, result, keyboard, config, conf.Lang)
+	}
+
+	saveTurn(user, message, photos, result, messageIDs, rewindID)
+	return responseID, usage
 }
 
-func addVisionMessage(bot *tgbotapi.BotAPI, message *tgbotapi.Message, config *config.Config) openai.ChatCompletionMessage {
-	if len(message.Photo) > 0 {
-		// Assuming you want the largest photo size
-		photoSize := message.Photo[len(message.Photo)-1]
-		fileID := photoSize.FileID
+// saveTurn записывает запрос и ответ в историю вместе с ID сообщений, чтобы ответом
+// на них можно было вернуться к этому месту. Откат к rewindID выполняется только
+// здесь, когда новый ответ получен.
+func saveTurn(u *user.UsageTracker, message *tgbotapi.Message, photos []string, answer string, answerIDs []int, rewindID int) {
+	if rewindID != 0 {
+		u.RewindTo(message.Chat.ID, rewindID)
+	}
+	u.AddImageMessage(openai.ChatMessageRoleUser, message.Text, photos, message.Chat.ID, message.MessageID)
+	u.AddMessage(openai.ChatMessageRoleAssistant, answer, message.Chat.ID, answerIDs...)
+}
 
-		// Download the photo
-		file, err := bot.GetFile(tgbotapi.FileConfig{FileID: fileID})
-		if err != nil {
-			log.Printf("Error getting file: %v", err)
-			return openai.ChatCompletionMessage{
-				Role:    openai.ChatMessageRoleUser,
-				Content: message.Text,
+// estimateUsage оценивает число токенов запроса и ответа, если провайдер их не сообщил
+func estimateUsage(messages []openai.ChatCompletionMessage, answer string) *openai.Usage {
+	var prompt int
+	for _, msg := range messages {
+		prompt += user.EstimateTokens(msg.Content)
+		for _, part := range msg.MultiContent {
+			prompt += user.EstimateTokens(part.Text)
+		}
+	}
+	completion := user.EstimateTokens(answer)
+	return &openai.Usage{PromptTokens: prompt, CompletionTokens: completion, TotalTokens: prompt + completion}
+}
+
+// sendPlaceholder отправляет заглушку ответа. При повторной генерации заглушкой
+// становится первое сообщение прежнего ответа, а остальные его части удаляются.
+func sendPlaceholder(bot *tgbotapi.BotAPI, request ChatRequest, text string) (tgbotapi.Message, error) {
+	message := request.Message
+	if len(request.AnswerIDs) > 0 {
+		for _, id := range request.AnswerIDs[1:] {
+			if _, err := bot.Request(tgbotapi.NewDeleteMessage(message.Chat.ID, id)); err != nil {
+				log.Printf("Error deleting previous answer part: %v", err)
 			}
 		}
+		sent, err := bot.Send(tgbotapi.NewEditMessageText(message.Chat.ID, request.AnswerIDs[0], text))
+		if err == nil {
+			return sent, nil
+		}
+		// Например, фото нельзя превратить в текстовое сообщение
+		log.Printf("Error reusing previous answer, sending a new one: %v", err)
+		bot.Request(tgbotapi.NewDeleteMessage(message.Chat.ID, request.AnswerIDs[0]))
+	}
+
+	placeholder := tgbotapi.NewMessage(message.Chat.ID, text)
+	placeholder.ReplyToMessageID = message.MessageID
+	return bot.Send(placeholder)
+}
 
-		// Access the file URL
-		fileURL := file.Link(bot.Token)
-		fmt.Println("Photo URL:", fileURL)
-		if message.Text == "" {
-			message.Text = config.VisionPrompt
-		}
-
-		return openai.ChatCompletionMessage{
-			Role: openai.ChatMessageRoleUser,
-			MultiContent: []openai.ChatMessagePart{
-				{
-					Type: openai.ChatMessagePartTypeText,
-					Text: message.Text,
-				},
-				{
-					Type: openai.ChatMessagePartTypeImageURL,
-					ImageURL: &openai.ChatMessageImageURL{
-						URL:    fileURL,
-						Detail: openai.ImageURLDetail(config.VisionDetails),
-					},
-				},
-			},
+// sendAnswer заменяет заглушку первой частью ответа и отправляет остальные части
+// ответами на сообщение пользователя. Клавиатура прикрепляется к последней части.
+// Возвращает ID всех сообщений с ответом.
+func sendAnswer(bot *tgbotapi.BotAPI, message *tgbotapi.Message, placeholderID int, answer string, keyboard tgbotapi.InlineKeyboardMarkup, config *config.Config, language string) []int {
+	messageIDs := []int{placeholderID}
+	chunks := SplitMessage(answer, telegramChunkLimit)
+	tooLong := config.MaxMessageChunks > 0 && len(chunks) > config.MaxMessageChunks
+	if tooLong {
+		chunks = chunks[:1]
+	}
+
+	for i, chunk := range chunks {
+		last := i == len(chunks)-1
+		if i == 0 {
+			_, err := sendRendered(bot, chunk, func(text, parseMode string) tgbotapi.Chattable {
+				edit := tgbotapi.NewEditMessageText(message.Chat.ID, placeholderID, text)
+				edit.ParseMode = parseMode
+				if last {
+					edit.ReplyMarkup = &keyboard
+				}
+				return edit
+			})
+			if err != nil {
+				log.Printf("Error sending final message: %v", err)
+			}
+			continue
 		}
-	} else {
-		return openai.ChatCompletionMessage{
-			Role:    openai.ChatMessageRoleUser,
-			Content: message.Text,
+		sent, err := sendRendered(bot, chunk, func(text, parseMode string) tgbotapi.Chattable {
+			msg := tgbotapi.NewMessage(message.Chat.ID, text)
+			msg.ParseMode = parseMode
+			msg.ReplyToMessageID = message.MessageID
+			if last {
+				msg.ReplyMarkup = keyboard
+			}
+			return msg
+		})
+		if err != nil {
+			log.Printf("Error sending message part %d: %v", i+1, err)
+			continue
+		}
+		messageIDs = append(messageIDs, sent.MessageID)
+	}
+
+	// Полный ответ прикладываем файлом
+	if tooLong {
+		doc := tgbotapi.NewDocument(message.Chat.ID, tgbotapi.FileBytes{
+			Name:  "answer.md",
+			Bytes: []byte(answer),
+		})
+		doc.Caption = lang.Translate("answerFile", language)
+		doc.ReplyToMessageID = message.MessageID
+		if sent, err := bot.Send(doc); err != nil {
+			log.Printf("Error sending answer file: %v", err)
+		} else {
+			messageIDs = append(messageIDs, sent.MessageID)
+		}
+	}
+	return messageIDs
+}
+
+// sendRendered отправляет Markdown-текст, преобразованный в HTML Telegram.
+// Если Telegram не принимает разметку, текст отправляется как есть без неё.
+func sendRendered(bot *tgbotapi.BotAPI, markdown string, build func(text, parseMode string) tgbotapi.Chattable) (tgbotapi.Message, error) {
+	sent, err := bot.Send(build(RenderHTML(markdown), tgbotapi.ModeHTML))
+	if err == nil {
+		return sent, nil
+	}
+	log.Printf("Error sending rendered message, falling back to plain text: %v", err)
+	return bot.Send(build(markdown, ""))
+}
+
+// truncateText обрезает текст до limit единиц UTF-16, в которых Telegram считает длину
+func truncateText(text string, limit int) string {
+	units := 0
+	for i, r := range text {
+		units += utf16.RuneLen(r)
+		if units > limit {
+			return text[:i]
 		}
 	}
+	return text
 }
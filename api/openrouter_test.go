@@ -0,0 +1,26 @@
+package api
+
+import "testing"
+
+func TestTruncateText(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    string
+		limit int
+		want  string
+	}{
+		{"short", "hello", 10, "hello"},
+		{"exact", "hello", 5, "hello"},
+		{"cut", "hello", 3, "hel"},
+		{"cyrillic", "привет", 3, "при"},
+		{"emoji takes two units", "😀😀😀", 4, "😀😀"},
+		{"emoji not split in half", "a😀", 2, "a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := truncateText(tt.in, tt.limit); got != tt.want {
+				t.Errorf("truncateText(%q, %d) = %q, want %q", tt.in, tt.limit, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"openrouter-bot/config"
+	"openrouter-bot/user"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// OpenRouterParams — параметры сэмплирования и поля OpenRouter, которые
+// дописываются в тело запроса. В openai.ChatCompletionRequest нет части полей,
+// а у остальных omitempty не даёт отправить явно заданный ноль.
+type OpenRouterParams struct {
+	Temperature       *float64                `json:"temperature,omitempty"`
+	TopP              *float64                `json:"top_p,omitempty"`
+	TopK              *int                    `json:"top_k,omitempty"`
+	MinP              *float64                `json:"min_p,omitempty"`
+	TopA              *float64                `json:"top_a,omitempty"`
+	FrequencyPenalty  *float64                `json:"frequency_penalty,omitempty"`
+	PresencePenalty   *float64                `json:"presence_penalty,omitempty"`
+	RepetitionPenalty *float64                `json:"repetition_penalty,omitempty"`
+	Provider          *config.ProviderRouting `json:"provider,omitempty"`
+}
+
+type paramsKey struct{}
+
+// ModelParameters returns the sampling parameters from config with the
+// overrides of the user's current conversation.
+func ModelParameters(conf *config.Config, u *user.UsageTracker) config.ModelParameters {
+	params := conf.Model
+	for name, value := range u.GetParameters() {
+		params.Set(name, value)
+	}
+	return params
+}
+
+// buildChatRequest собирает запрос к модели со всеми параметрами сэмплирования.
+// Параметры, которых нет в supported_parameters модели из каталога, не отправляются.
+func buildChatRequest(catalog *ModelCatalog, model string, messages []openai.ChatCompletionMessage, maxTokens int, params config.ModelParameters) (openai.ChatCompletionRequest, OpenRouterParams) {
+	info, known := catalog.Get(model)
+	supported := func(name string) bool {
+		return !known || len(info.SupportedParameters) == 0 || info.SupportsParameter(name)
+	}
+
+	req := openai.ChatCompletionRequest{
+		Model:     model,
+		Messages:  messages,
+		MaxTokens: maxTokens,
+	}
+	var extra OpenRouterParams
+	if supported("temperature") {
+		extra.Temperature = params.Temperature
+	}
+	if supported("top_p") {
+		extra.TopP = params.TopP
+	}
+	if supported("top_k") && params.TopK != nil {
+		topK := int(*params.TopK)
+		extra.TopK = &topK
+	}
+	if supported("min_p") {
+		extra.MinP = params.MinP
+	}
+	if supported("top_a") {
+		extra.TopA = params.TopA
+	}
+	if supported("frequency_penalty") {
+		extra.FrequencyPenalty = params.FrequencyPenalty
+	}
+	if supported("presence_penalty") {
+		extra.PresencePenalty = params.PresencePenalty
+	}
+	if supported("repetition_penalty") {
+		extra.RepetitionPenalty = params.RepetitionPenalty
+	}
+	if supported("seed") {
+		req.Seed = params.Seed
+	}
+	if supported("stop") {
+		req.Stop = params.Stop
+	}
+	if !params.Provider.IsEmpty() {
+		extra.Provider = &params.Provider
+	}
+	return req, extra
+}
+
+// withParams добавляет к контексту поля OpenRouter, которые ParamsClient допишет в запрос
+func withParams(ctx context.Context, extra OpenRouterParams) context.Context {
+	return context.WithValue(ctx, paramsKey{}, extra)
+}
+
+// ParamsClient is an HTTP client for go-openai that adds the OpenRouter fields
+// stored in the request context to the JSON body of the request.
+type ParamsClient struct {
+	Client openai.HTTPDoer
+}
+
+func (c ParamsClient) Do(req *http.Request) (*http.Response, error) {
+	extra, ok := req.Context().Value(paramsKey{}).(OpenRouterParams)
+	if !ok || req.Body == nil {
+		return c.Client.Do(req)
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	body, err = mergeJSON(body, extra)
+	if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	req.ContentLength = int64(len(body))
+	return c.Client.Do(req)
+}
+
+// mergeJSON добавляет поля extra к JSON-объекту body
+func mergeJSON(body []byte, extra any) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(extra)
+	if err != nil {
+		return nil, err
+	}
+	var extraFields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &extraFields); err != nil {
+		return nil, err
+	}
+	for name, value := range extraFields {
+		fields[name] = value
+	}
+	return json.Marshal(fields)
+}
@@ -0,0 +1,168 @@
+package api
+
+import (
+	"fmt"
+	"openrouter-bot/lang"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Фильтры списка моделей
+const (
+	FilterAll    = "all"
+	FilterFree   = "free"
+	FilterPaid   = "paid"
+	FilterVision = "vision"
+)
+
+// Префиксы callback data кнопок выбора модели
+const (
+	CallbackModelsPage   = "mp"
+	CallbackModelDetails = "md"
+	CallbackModelUse     = "mu"
+	CallbackModelSet     = "ms"
+)
+
+// Telegram ограничивает callback data 64 байтами
+const callbackDataLimit = 64
+
+const modelsPageSize = 8
+
+var modelFilters = []string{FilterFree, FilterPaid, FilterVision, FilterAll}
+
+// ModelsPage builds a page of the model picker
+func ModelsPage(models []Model, filter string, page int, language string) (string, tgbotapi.InlineKeyboardMarkup) {
+	filtered := FilterModels(models, filter)
+	pages := (len(filtered) + modelsPageSize - 1) / modelsPageSize
+	if pages == 0 {
+		pages = 1
+	}
+	page = max(0, min(page, pages-1))
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	start := page * modelsPageSize
+	end := min(start+modelsPageSize, len(filtered))
+	for i := start; i < end; i++ {
+		data := modelCallback(callbackData(CallbackModelDetails, filter, strconv.Itoa(page)), filtered[i].ID, i)
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(filtered[i].ID, data),
+		))
+	}
+
+	var filterRow []tgbotapi.InlineKeyboardButton
+	for _, f := range modelFilters {
+		label := lang.Translate("picker.filter."+f, language)
+		if f == filter {
+			label = "✅ " + label
+		}
+		filterRow = append(filterRow, tgbotapi.NewInlineKeyboardButtonData(label, callbackData(CallbackModelsPage, f, "0")))
+	}
+	rows = append(rows, filterRow)
+
+	navRow := []tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardButtonData("«", callbackData(CallbackModelsPage, filter, strconv.Itoa((page-1+pages)%pages))),
+		tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("%d/%d", page+1, pages), callbackData(CallbackModelsPage, filter, strconv.Itoa(page))),
+		tgbotapi.NewInlineKeyboardButtonData("»", callbackData(CallbackModelsPage, filter, strconv.Itoa((page+1)%pages))),
+	}
+	rows = append(rows, navRow)
+
+	text := fmt.Sprintf(lang.Translate("picker.page", language), lang.Translate("picker.filter."+filter, language), len(filtered))
+	return text, tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// ModelDetails builds the detail view of a model with a "Use this model" button
+func ModelDetails(model Model, filter string, page, index int, language string) (string, tgbotapi.InlineKeyboardMarkup) {
+	description := model.Description
+	if runeLen(description) > 500 {
+		description = truncateText(description, 500) + "…"
+	}
+	modalities := strings.Join(model.Architecture.InputModalities, ", ")
+	if modalities == "" {
+		modalities = "text"
+	}
+
+	text := fmt.Sprintf(lang.Translate("picker.details", language),
+		htmlReplacer.Replace(model.ID),
+		htmlReplacer.Replace(description),
+		model.ContextLength,
+		formatPrice(model.Pricing.Prompt),
+		formatPrice(model.Pricing.Completion),
+		htmlReplacer.Replace(modalities),
+	)
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(lang.Translate("picker.use", language), modelCallback(callbackData(CallbackModelUse, filter), model.ID, index)),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(lang.Translate("picker.back", language), callbackData(CallbackModelsPage, filter, strconv.Itoa(page))),
+		),
+	)
+	return text, keyboard
+}
+
+// ModelSuggestions builds buttons that switch to one of the suggested models
+func ModelSuggestions(models []Model) tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, model := range models {
+		data := callbackData(CallbackModelSet, model.ID)
+		if len(data) > callbackDataLimit {
+			continue
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(model.ID, data)))
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// ParseCallbackData splits callback data into its prefix and arguments
+func ParseCallbackData(data string) (string, []string) {
+	prefix, rest, _ := strings.Cut(data, ":")
+	if prefix == CallbackModelSet {
+		// ID модели может содержать двоеточие (например, ":free")
+		return prefix, []string{rest}
+	}
+	return prefix, strings.Split(rest, ":")
+}
+
+// modelCallback дописывает к data ссылку на модель: её ID, если он помещается
+// в лимит Telegram, иначе номер в отфильтрованном списке с префиксом #.
+// Номер может указать на другую модель после обновления каталога.
+func modelCallback(data, id string, index int) string {
+	if withID := data + ":" + id; len(withID) <= callbackDataLimit {
+		return withID
+	}
+	return data + ":#" + strconv.Itoa(index)
+}
+
+// FindModel returns the index of the model referenced by callback data (its ID
+// or #index) in the filtered list.
+func FindModel(models []Model, ref string) (int, bool) {
+	if number, ok := strings.CutPrefix(ref, "#"); ok {
+		index, err := strconv.Atoi(number)
+		return index, err == nil && index >= 0 && index < len(models)
+	}
+	for i, model := range models {
+		if model.ID == ref {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func callbackData(prefix string, args ...string) string {
+	return prefix + ":" + strings.Join(args, ":")
+}
+
+// formatPrice converts a per-token price into a price per million tokens
+func formatPrice(price string) string {
+	value, err := strconv.ParseFloat(price, 64)
+	if err != nil {
+		return "—"
+	}
+	if value == 0 {
+		return "0"
+	}
+	return "$" + strconv.FormatFloat(value*1_000_000, 'f', -1, 64)
+}
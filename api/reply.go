@@ -0,0 +1,72 @@
+package api
+
+import (
+	"fmt"
+	"openrouter-bot/user"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	// Контекст сообщения, на которое ответил пользователь
+	replyPrefix = "In reply to %s:\n%s\n\n"
+	// Фрагмент, который пользователь процитировал в ответе
+	quotePrefix = "Quoted fragment:\n%s\n\n"
+	// Автор сообщения в контексте ответа, если это ответ бота
+	assistantAuthor = "your earlier answer"
+)
+
+// replyContext готовит контекст для ответа на сообщение. Если пользователь
+// ответил на ответ бота из истории и ему можно менять историю (rewind),
+// разговор продолжается с этого ответа: возвращается ID ответа, к которому
+// история откатится после нового ответа. Иначе текст сообщения добавляется к запросу.
+// quote — процитированный фрагмент сообщения, если он есть.
+func replyContext(bot *tgbotapi.BotAPI, message *tgbotapi.Message, quote string, history *user.UsageTracker, rewind bool) (string, int) {
+	reply := message.ReplyToMessage
+	if reply == nil {
+		return "", 0
+	}
+
+	var prompt strings.Builder
+	rewindID := 0
+	fromBot := reply.From != nil && reply.From.ID == bot.Self.ID
+	if fromBot && rewind {
+		if _, ok := history.MessagesUntil(message.Chat.ID, reply.MessageID); ok {
+			rewindID = reply.MessageID
+		}
+	}
+	if rewindID == 0 {
+		text := reply.Text
+		if text == "" {
+			text = reply.Caption
+		}
+		if text != "" {
+			author := assistantAuthor
+			if !fromBot {
+				author = AuthorName(reply.From)
+			}
+			prompt.WriteString(fmt.Sprintf(replyPrefix, author, quoteText(text)))
+		}
+	}
+	if quote != "" {
+		prompt.WriteString(fmt.Sprintf(quotePrefix, quoteText(quote)))
+	}
+	return prompt.String(), rewindID
+}
+
+// quoteText оформляет текст как цитату Markdown
+func quoteText(text string) string {
+	return "> " + strings.ReplaceAll(strings.TrimSpace(text), "\n", "\n> ")
+}
+
+// AuthorName returns the name a Telegram user is shown to the model with.
+func AuthorName(from *tgbotapi.User) string {
+	if from == nil {
+		return "unknown"
+	}
+	if name := strings.TrimSpace(from.FirstName + " " + from.LastName); name != "" {
+		return name
+	}
+	return from.UserName
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"openrouter-bot/config"
+	"openrouter-bot/user"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// compactHistory keeps the history within MAX_HISTORY_SIZE and the model
+// context. With SUMMARIZE_HISTORY enabled the overflowing oldest turns are
+// condensed into a summary at the head of the history instead of being dropped.
+// The summary is charged to payer.
+func compactHistory(ctx context.Context, client *openai.Client, conf *config.Config, u, payer *user.UsageTracker, catalog *ModelCatalog, model, text string) {
+	budget := -1
+	if contextLength := catalog.ContextLength(model); contextLength > 0 {
+		// Оценка токенов приблизительная, оставляем запас
+		budget = max(contextLength*tokenBudgetPercent/100-u.GetMaxTokens(conf)-
+			user.EstimateMessageTokens(u.GetSystemPrompt(conf))-user.EstimateMessageTokens(text), 0)
+	}
+
+	if !conf.SummarizeHistory {
+		u.DropOldest(u.HistoryOverflow(conf.MaxHistorySize, budget))
+		return
+	}
+
+	// Резервируем место под само краткое содержание
+	summaryBudget := budget
+	if budget >= 0 {
+		summaryBudget = max(budget-conf.SummaryMaxTokens, 0)
+	}
+	n := u.HistoryOverflow(max(conf.MaxHistorySize-1, 0), summaryBudget)
+	if n == 0 {
+		return
+	}
+
+	summary, err := summarize(ctx, client, conf, u, payer, catalog, u.GetMessages()[:n])
+	if err != nil {
+		log.Printf("Error summarizing history for user %s: %v", u.UserID, err)
+		// После /stop история остаётся как есть, иначе её старая часть пропала бы без краткого содержания
+		if ctx.Err() != nil {
+			return
+		}
+		u.DropOldest(u.HistoryOverflow(conf.MaxHistorySize, budget))
+		return
+	}
+	u.ReplaceWithSummary(n, summary)
+}
+
+// summarize condenses messages into a summary using SUMMARY_MODEL
+func summarize(ctx context.Context, client *openai.Client, conf *config.Config, u, payer *user.UsageTracker, catalog *ModelCatalog, messages []user.Message) (string, error) {
+	model := conf.SummaryModel
+	if model == "" {
+		model = u.GetModel(conf)
+	}
+
+	var transcript strings.Builder
+	for _, msg := range messages {
+		role := msg.Role
+		if msg.Summary {
+			role = "summary"
+		}
+		transcript.WriteString(role + ": " + msg.Content + "\n\n")
+	}
+
+	resp, err := client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+		Model:     model,
+		MaxTokens: conf.SummaryMaxTokens,
+		Messages: []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleSystem, Content: conf.SummaryPrompt},
+			{Role: openai.ChatMessageRoleUser, Content: transcript.String()},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	usage := resp.Usage
+	if err := RecordCost(ctx, payer, conf, catalog, model, resp.ID, &usage); err != nil {
+		log.Printf("Error recording summary cost for user %s: %v", payer.UserID, err)
+	}
+
+	if len(resp.Choices) == 0 || strings.TrimSpace(resp.Choices[0].Message.Content) == "" {
+		return "", fmt.Errorf("empty summary")
+	}
+	return summaryPrefix + strings.TrimSpace(resp.Choices[0].Message.Content), nil
+}
+
+// RecordCost adds the cost of a generation to the user's usage. The cost is
+// requested from OpenRouter and estimated from catalog prices if that fails.
+func RecordCost(ctx context.Context, u *user.UsageTracker, conf *config.Config, catalog *ModelCatalog, model, id string, usage *openai.Usage) error {
+	if conf.Model.Type == "openrouter" && id != "" {
+		err := u.GetUsageFromApi(ctx, id, conf)
+		if err == nil {
+			return nil
+		}
+		log.Printf("Error getting usage for user %s: %v", u.UserID, err)
+	}
+
+	// Стоимость не получена от API — оцениваем её по ценам из каталога моделей
+	if usage == nil {
+		return fmt.Errorf("no usage for generation %q", id)
+	}
+	cost, ok := catalog.EstimateCost(model, usage.PromptTokens, usage.CompletionTokens, 0)
+	if !ok {
+		return fmt.Errorf("unknown model %s", model)
+	}
+	log.Printf("Estimated cost for user %s: %.6f", u.UserID, cost)
+	u.AddCost(cost)
+	return nil
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"openrouter-bot/config"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/sashabaranov/go-openai"
+)
+
+// ErrTranscriptionDisabled — распознавание голоса не настроено
+var ErrTranscriptionDisabled = errors.New("transcription is not configured")
+
+// HasAudio reports whether the message is a voice note or an audio file.
+func HasAudio(message *tgbotapi.Message) bool {
+	return message.Voice != nil || message.Audio != nil
+}
+
+// TranscribeAudio downloads a voice note or an audio file and converts it to
+// text with the OpenAI-compatible /audio/transcriptions endpoint from config.
+func TranscribeAudio(ctx context.Context, bot *tgbotapi.BotAPI, conf *config.Config, message *tgbotapi.Message) (string, error) {
+	if conf.TranscriptionBaseURL == "" {
+		return "", ErrTranscriptionDisabled
+	}
+
+	var fileID, name string
+	switch {
+	case message.Voice != nil:
+		fileID, name = message.Voice.FileID, "voice.ogg"
+	case message.Audio != nil:
+		fileID, name = message.Audio.FileID, message.Audio.FileName
+		if name == "" {
+			name = "audio.mp3"
+		}
+	default:
+		return "", errors.New("message has no audio")
+	}
+
+	data, err := downloadFile(ctx, bot, fileID, telegramFileLimit)
+	if err != nil {
+		return "", err
+	}
+
+	clientConfig := openai.DefaultConfig(conf.TranscriptionApiKey)
+	clientConfig.BaseURL = conf.TranscriptionBaseURL
+	client := openai.NewClientWithConfig(clientConfig)
+	resp, err := client.CreateTranscription(ctx, openai.AudioRequest{
+		Model:    conf.TranscriptionModel,
+		FilePath: name,
+		Reader:   bytes.NewReader(data),
+		Language: conf.TranscriptionLanguage,
+		Format:   openai.AudioResponseFormatJSON,
+	})
+	if err != nil {
+		return "", fmt.Errorf("transcription: %w", err)
+	}
+	return strings.TrimSpace(resp.Text), nil
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	_ "image/png"
+	"log"
+	"openrouter-bot/config"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/sashabaranov/go-openai"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	// Наибольшая сторона изображения для VISION_DETAIL=low и для остальных режимов
+	visionLowMaxSide  = 512
+	visionHighMaxSide = 2048
+	// Провайдеры ограничивают размер изображения (например, 5 МБ), base64 добавляет к нему треть
+	visionMaxBytes    = 3 << 20
+	visionJPEGQuality = 85
+)
+
+// AcceptsImages reports whether the model accepts images. The catalog's input
+// modalities decide; VISION is used only for models missing from the catalog.
+func AcceptsImages(catalog *ModelCatalog, conf *config.Config, id string) bool {
+	if model, ok := catalog.Get(id); ok {
+		return model.HasVision()
+	}
+	return conf.Vision == "true"
+}
+
+// VisionModels returns the models from ids that accept images according to the catalog
+func VisionModels(catalog *ModelCatalog, ids []string) []string {
+	var models []string
+	for _, id := range ids {
+		if model, ok := catalog.Get(id); ok && model.HasVision() {
+			models = append(models, id)
+		}
+	}
+	return models
+}
+
+// visionMessage собирает сообщение из текста и изображений. Изображения
+// хранятся как file_id Telegram и заново получаются при каждом запросе.
+func visionMessage(ctx context.Context, bot *tgbotapi.BotAPI, config *config.Config, role, text string, fileIDs []string) openai.ChatCompletionMessage {
+	parts := []openai.ChatMessagePart{
+		{
+			Type: openai.ChatMessagePartTypeText,
+			Text: text,
+		},
+	}
+	for _, fileID := range fileIDs {
+		url, err := imageDataURL(ctx, bot, fileID, config.VisionDetails)
+		if err != nil {
+			log.Printf("Error preparing image: %v", err)
+			continue
+		}
+		parts = append(parts, openai.ChatMessagePart{
+			Type: openai.ChatMessagePartTypeImageURL,
+			ImageURL: &openai.ChatMessageImageURL{
+				URL:    url,
+				Detail: openai.ImageURLDetail(config.VisionDetails),
+			},
+		})
+	}
+	if len(parts) == 1 {
+		return openai.ChatCompletionMessage{Role: role, Content: text}
+	}
+	return openai.ChatCompletionMessage{Role: role, MultiContent: parts}
+}
+
+// imageDataURL скачивает изображение и возвращает его как base64 data URL.
+// Ссылка Telegram содержит токен бота, поэтому провайдеру модели передаются
+// только сами данные.
+func imageDataURL(ctx context.Context, bot *tgbotapi.BotAPI, fileID, detail string) (string, error) {
+	data, err := downloadFile(ctx, bot, fileID, telegramFileLimit)
+	if err != nil {
+		return "", err
+	}
+	maxSide := visionHighMaxSide
+	if detail == string(openai.ImageURLDetailLow) {
+		maxSide = visionLowMaxSide
+	}
+	data, err = fitImage(data, maxSide, visionMaxBytes)
+	if err != nil {
+		return "", err
+	}
+	return "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// fitImage уменьшает изображение до maxSide по большей стороне и пережимает в
+// JPEG, пока оно не станет меньше maxBytes. Подходящий JPEG возвращается как есть.
+func fitImage(data []byte, maxSide, maxBytes int) ([]byte, error) {
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decode image: %w", err)
+	}
+	bounds := img.Bounds()
+	if format == "jpeg" && max(bounds.Dx(), bounds.Dy()) <= maxSide && len(data) <= maxBytes {
+		return data, nil
+	}
+
+	side := min(max(bounds.Dx(), bounds.Dy()), maxSide)
+	for {
+		scale := float64(side) / float64(max(bounds.Dx(), bounds.Dy()))
+		width, height := max(int(float64(bounds.Dx())*scale), 1), max(int(float64(bounds.Dy())*scale), 1)
+
+		// JPEG не поддерживает прозрачность, поэтому фон заливается белым
+		resized := image.NewRGBA(image.Rect(0, 0, width, height))
+		draw.Draw(resized, resized.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+		draw.CatmullRom.Scale(resized, resized.Bounds(), img, bounds, draw.Over, nil)
+
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, resized, &jpeg.Options{Quality: visionJPEGQuality}); err != nil {
+			return nil, fmt.Errorf("encode image: %w", err)
+		}
+		if buf.Len() <= maxBytes || side <= visionLowMaxSide {
+			return buf.Bytes(), nil
+		}
+		side = side * 3 / 4
+	}
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"log"
+	"math"
 	"reflect"
 
 	"github.com/joho/godotenv"
@@ -16,40 +17,186 @@ import (
 )
 
 type Config struct {
-	TelegramBotToken   string
-	OpenAIApiKey       string
-	Model              ModelParameters
-	MaxTokens          int
-	BotLanguage        string
-	OpenAIBaseURL      string
-	SystemPrompt       string
-	BudgetPeriod       string
-	GuestBudget        float64
-	UserBudget         float64
-	AdminChatIDs       []int64
-	AllowedUserChatIDs []int64
-	MaxHistorySize     int
-	MaxHistoryTime     int
-	Vision             string
-	VisionPrompt       string
-	VisionDetails      string
-	StatsMinRole       string
-	Lang               string
+	TelegramBotToken      string
+	OpenAIApiKey          string
+	Model                 ModelParameters
+	MaxTokens             int
+	BotLanguage           string
+	OpenAIBaseURL         string
+	SystemPrompt          string
+	BudgetPeriod          string
+	GuestBudget           float64
+	UserBudget            float64
+	AdminChatIDs          []int64
+	AllowedUserChatIDs    []int64
+	AllowedModels         []string
+	MaxHistorySize        int
+	MaxHistoryTime        int
+	HistoryStore          string
+	HistoryDB             string
+	SummarizeHistory      bool
+	SummaryModel          string
+	SummaryMaxTokens      int
+	SummaryPrompt         string
+	Personas              []Persona
+	GroupMention          bool
+	GroupReply            bool
+	GroupSharedHistory    bool
+	MaxMessageChunks      int
+	TranscriptionBaseURL  string
+	TranscriptionApiKey   string
+	TranscriptionModel    string
+	TranscriptionLanguage string
+	DocumentMaxSize       int // В байтах
+	DocumentMaxTokens     int
+	DocumentPrompt        string
+	ImageModel            string
+	ModelsRefreshInterval int
+	Vision                string
+	VisionPrompt          string
+	VisionDetails         string
+	StatsMinRole          string
+	Lang                  string
 }
 
+// ModelParameters — модель и параметры сэмплирования; параметр nil не задан и не отправляется
 type ModelParameters struct {
 	Type              string
 	ModelName         string
 	ModelNameDefault  string
 	ModelReq          openai.ChatCompletionRequest
-	FrequencyPenalty  float64
-	MinP              float64
-	PresencePenalty   float64
-	RepetitionPenalty float64
-	Temperature       float64
-	TopA              float64
-	TopK              float64
-	TopP              float64
+	FrequencyPenalty  *float64
+	MinP              *float64
+	PresencePenalty   *float64
+	RepetitionPenalty *float64
+	Temperature       *float64
+	TopA              *float64
+	TopK              *float64
+	TopP              *float64
+	Seed              *int
+	Stop              []string
+	Provider          ProviderRouting
+}
+
+// ProviderRouting — выбор провайдеров OpenRouter для запроса (поле provider)
+type ProviderRouting struct {
+	Order             []string `json:"order,omitempty"`
+	Only              []string `json:"only,omitempty"`
+	Ignore            []string `json:"ignore,omitempty"`
+	AllowFallbacks    *bool    `json:"allow_fallbacks,omitempty"`
+	RequireParameters bool     `json:"require_parameters,omitempty"`
+	DataCollection    string   `json:"data_collection,omitempty"`
+	Sort              string   `json:"sort,omitempty"`
+}
+
+// IsEmpty reports whether no provider preferences are set.
+func (p ProviderRouting) IsEmpty() bool {
+	return len(p.Order) == 0 && len(p.Only) == 0 && len(p.Ignore) == 0 && p.AllowFallbacks == nil &&
+		!p.RequireParameters && p.DataCollection == "" && p.Sort == ""
+}
+
+// SamplingParameters — параметры, которые можно переопределить для разговора командой /params
+var SamplingParameters = []string{
+	"temperature", "top_p", "top_k", "min_p", "top_a",
+	"frequency_penalty", "presence_penalty", "repetition_penalty", "seed",
+}
+
+// ParameterRange — допустимые значения параметра сэмплирования
+type ParameterRange struct {
+	Min, Max float64
+	Integer  bool
+}
+
+// ParameterRanges — диапазоны параметров по документации OpenRouter
+var ParameterRanges = map[string]ParameterRange{
+	"temperature":        {Min: 0, Max: 2},
+	"top_p":              {Min: 0, Max: 1},
+	"top_k":              {Min: 0, Max: math.MaxInt32, Integer: true},
+	"min_p":              {Min: 0, Max: 1},
+	"top_a":              {Min: 0, Max: 1},
+	"frequency_penalty":  {Min: -2, Max: 2},
+	"presence_penalty":   {Min: -2, Max: 2},
+	"repetition_penalty": {Min: 0, Max: 2},
+	"seed":               {Min: math.MinInt32, Max: math.MaxInt32, Integer: true},
+}
+
+// ParseParameter разбирает значение параметра сэмплирования и проверяет его диапазон.
+func ParseParameter(name, text string) (float64, error) {
+	value, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+	if err != nil {
+		return 0, err
+	}
+	return value, CheckParameter(name, value)
+}
+
+// CheckParameter reports an error if the value is out of the parameter's range.
+// NaN and infinities are rejected too: they cannot be encoded as JSON.
+func CheckParameter(name string, value float64) error {
+	limits, ok := ParameterRanges[name]
+	switch {
+	case !ok:
+		return fmt.Errorf("unknown parameter %q", name)
+	case math.IsNaN(value) || math.IsInf(value, 0):
+		return fmt.Errorf("%s must be a finite number", name)
+	case value < limits.Min || value > limits.Max:
+		return fmt.Errorf("%s must be between %g and %g", name, limits.Min, limits.Max)
+	case limits.Integer && value != math.Trunc(value):
+		return fmt.Errorf("%s must be an integer", name)
+	}
+	return nil
+}
+
+// Get returns the value of the sampling parameter and whether it is set.
+func (p ModelParameters) Get(name string) (float64, bool) {
+	if name == "seed" {
+		if p.Seed == nil {
+			return 0, false
+		}
+		return float64(*p.Seed), true
+	}
+	field := p.sampling(name)
+	if field == nil || *field == nil {
+		return 0, false
+	}
+	return **field, true
+}
+
+// Set changes the sampling parameter and reports whether the name is known.
+func (p *ModelParameters) Set(name string, value float64) bool {
+	if name == "seed" {
+		seed := int(value)
+		p.Seed = &seed
+		return true
+	}
+	field := p.sampling(name)
+	if field == nil {
+		return false
+	}
+	*field = &value
+	return true
+}
+
+// sampling возвращает поле параметра сэмплирования по его имени в API
+func (p *ModelParameters) sampling(name string) **float64 {
+	switch name {
+	case "temperature":
+		return &p.Temperature
+	case "top_p":
+		return &p.TopP
+	case "top_k":
+		return &p.TopK
+	case "min_p":
+		return &p.MinP
+	case "top_a":
+		return &p.TopA
+	case "frequency_penalty":
+		return &p.FrequencyPenalty
+	case "presence_penalty":
+		return &p.PresencePenalty
+	case "repetition_penalty":
+		return &p.RepetitionPenalty
+	}
+	return nil
 }
 
 func Load() (*Config, error) {
@@ -66,34 +213,87 @@ func Load() (*Config, error) {
 	viper.SetDefault("BUDGET_PERIOD", "monthly")
 	viper.SetDefault("MAX_HISTORY_SIZE", 10)
 	viper.SetDefault("MAX_HISTORY_TIME", 60)
+	viper.SetDefault("HISTORY_STORE", "file")
+	viper.SetDefault("SUMMARY_MAX_TOKENS", 500)
+	viper.SetDefault("SUMMARY_PROMPT", "Summarize the conversation below in the language it is written in. "+
+		"Keep facts, decisions, names, numbers, code and open questions needed to continue it. "+
+		"If it starts with a summary of an earlier part, merge it into the new summary. Answer with the summary only.")
+	viper.SetDefault("GROUP_MENTION", true)
+	viper.SetDefault("GROUP_REPLY", true)
+	viper.SetDefault("TRANSCRIPTION_MODEL", "whisper-1")
+	viper.SetDefault("DOCUMENT_MAX_SIZE", 10)
+	viper.SetDefault("DOCUMENT_MAX_TOKENS", 8000)
+	viper.SetDefault("DOCUMENT_PROMPT", "Summarize this document.")
 	viper.SetDefault("LANG", "en")
+	viper.SetDefault("MODELS_REFRESH_INTERVAL", 60)
 
 	config := &Config{
 		TelegramBotToken: os.Getenv("TELEGRAM_BOT_TOKEN"),
 		OpenAIApiKey:     os.Getenv("API_KEY"),
 		Model: ModelParameters{
-			Type:             viper.GetString("TYPE"),
-			ModelName:        viper.GetString("MODEL"),
-			ModelNameDefault: viper.GetString("MODEL"),
-			Temperature:      viper.GetFloat64("TEMPERATURE"),
-			TopP:             viper.GetFloat64("TOP_P"),
+			Type:              viper.GetString("TYPE"),
+			ModelName:         viper.GetString("MODEL"),
+			ModelNameDefault:  viper.GetString("MODEL"),
+			Temperature:       getOptionalFloat("TEMPERATURE"),
+			TopP:              getOptionalFloat("TOP_P"),
+			TopK:              getOptionalFloat("TOP_K"),
+			MinP:              getOptionalFloat("MIN_P"),
+			TopA:              getOptionalFloat("TOP_A"),
+			FrequencyPenalty:  getOptionalFloat("FREQUENCY_PENALTY"),
+			PresencePenalty:   getOptionalFloat("PRESENCE_PENALTY"),
+			RepetitionPenalty: getOptionalFloat("REPETITION_PENALTY"),
+			Seed:              getOptionalInt("SEED"),
+			Stop:              getStrList("STOP"),
+			Provider: ProviderRouting{
+				Order:             getStrList("PROVIDER_ORDER"),
+				Only:              getStrList("PROVIDER_ONLY"),
+				Ignore:            getStrList("PROVIDER_IGNORE"),
+				AllowFallbacks:    getOptionalBool("PROVIDER_ALLOW_FALLBACKS"),
+				RequireParameters: viper.GetBool("PROVIDER_REQUIRE_PARAMETERS"),
+				DataCollection:    viper.GetString("PROVIDER_DATA_COLLECTION"),
+				Sort:              viper.GetString("PROVIDER_SORT"),
+			},
 		},
-		MaxTokens:          viper.GetInt("MAX_TOKENS"),
-		OpenAIBaseURL:      viper.GetString("BASE_URL"),
-		SystemPrompt:       viper.GetString("ASSISTANT_PROMPT"),
-		BudgetPeriod:       viper.GetString("BUDGET_PERIOD"),
-		GuestBudget:        viper.GetFloat64("GUEST_BUDGET"),
-		UserBudget:         viper.GetFloat64("USER_BUDGET"),
-		AdminChatIDs:       getStrAsIntList("ADMIN_IDS"),
-		AllowedUserChatIDs: getStrAsIntList("ALLOWED_USER_IDS"),
-		MaxHistorySize:     viper.GetInt("MAX_HISTORY_SIZE"),
-		MaxHistoryTime:     viper.GetInt("MAX_HISTORY_TIME"),
-		Vision:             viper.GetString("VISION"),
-		VisionPrompt:       viper.GetString("VISION_PROMPT"),
-		VisionDetails:      viper.GetString("VISION_DETAIL"),
-		StatsMinRole:       viper.GetString("STATS_MIN_ROLE"),
-		Lang:               viper.GetString("LANG"),
+		MaxTokens:             viper.GetInt("MAX_TOKENS"),
+		OpenAIBaseURL:         viper.GetString("BASE_URL"),
+		SystemPrompt:          viper.GetString("ASSISTANT_PROMPT"),
+		BudgetPeriod:          viper.GetString("BUDGET_PERIOD"),
+		GuestBudget:           viper.GetFloat64("GUEST_BUDGET"),
+		UserBudget:            viper.GetFloat64("USER_BUDGET"),
+		AdminChatIDs:          getStrAsIntList("ADMIN_IDS"),
+		AllowedUserChatIDs:    getStrAsIntList("ALLOWED_USER_IDS"),
+		AllowedModels:         getStrList("ALLOWED_MODELS"),
+		MaxHistorySize:        viper.GetInt("MAX_HISTORY_SIZE"),
+		MaxHistoryTime:        viper.GetInt("MAX_HISTORY_TIME"),
+		HistoryStore:          viper.GetString("HISTORY_STORE"),
+		HistoryDB:             viper.GetString("HISTORY_DB"),
+		SummarizeHistory:      viper.GetBool("SUMMARIZE_HISTORY"),
+		SummaryModel:          viper.GetString("SUMMARY_MODEL"),
+		SummaryMaxTokens:      viper.GetInt("SUMMARY_MAX_TOKENS"),
+		SummaryPrompt:         viper.GetString("SUMMARY_PROMPT"),
+		GroupMention:          viper.GetBool("GROUP_MENTION"),
+		GroupReply:            viper.GetBool("GROUP_REPLY"),
+		GroupSharedHistory:    viper.GetBool("GROUP_SHARED_HISTORY"),
+		MaxMessageChunks:      viper.GetInt("MAX_MESSAGE_CHUNKS"),
+		ModelsRefreshInterval: viper.GetInt("MODELS_REFRESH_INTERVAL"),
+		TranscriptionBaseURL:  viper.GetString("TRANSCRIPTION_BASE_URL"),
+		TranscriptionApiKey:   os.Getenv("TRANSCRIPTION_API_KEY"),
+		TranscriptionModel:    viper.GetString("TRANSCRIPTION_MODEL"),
+		TranscriptionLanguage: viper.GetString("TRANSCRIPTION_LANGUAGE"),
+		DocumentMaxSize:       viper.GetInt("DOCUMENT_MAX_SIZE") << 20,
+		DocumentMaxTokens:     viper.GetInt("DOCUMENT_MAX_TOKENS"),
+		DocumentPrompt:        viper.GetString("DOCUMENT_PROMPT"),
+		ImageModel:            viper.GetString("IMAGE_MODEL"),
+		Vision:                viper.GetString("VISION"),
+		VisionPrompt:          viper.GetString("VISION_PROMPT"),
+		VisionDetails:         viper.GetString("VISION_DETAIL"),
+		StatsMinRole:          viper.GetString("STATS_MIN_ROLE"),
+		Lang:                  viper.GetString("LANG"),
 	}
+	if err := viper.UnmarshalKey("personas", &config.Personas); err != nil {
+		log.Printf("Error loading personas: %v", err)
+	}
+	config.Personas = validPersonas(config.Personas)
 	if config.BudgetPeriod == "" {
 		log.Fatalf("Set budget_period in config file")
 	}
@@ -122,6 +322,43 @@ func getStrAsIntList(name string) []int64 {
 	return values
 }
 
+func getStrList(name string) []string {
+	var values []string
+	for _, str := range strings.Split(viper.GetString(name), ",") {
+		if value := strings.TrimSpace(str); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// getOptionalFloat возвращает nil, если переменная не задана
+func getOptionalFloat(name string) *float64 {
+	if viper.GetString(name) == "" {
+		return nil
+	}
+	value := viper.GetFloat64(name)
+	return &value
+}
+
+// getOptionalInt возвращает nil, если переменная не задана
+func getOptionalInt(name string) *int {
+	if viper.GetString(name) == "" {
+		return nil
+	}
+	value := viper.GetInt(name)
+	return &value
+}
+
+// getOptionalBool возвращает nil, если переменная не задана
+func getOptionalBool(name string) *bool {
+	if viper.GetString(name) == "" {
+		return nil
+	}
+	value := viper.GetBool(name)
+	return &value
+}
+
 func printConfig(c *Config) {
 	if c == nil {
 		fmt.Println("Config is nil")
@@ -148,6 +385,10 @@ func printStructFields(v reflect.Value) {
 	for i := 0; i < v.NumField(); i++ {
 		field := v.Field(i)
 		fieldName := t.Field(i).Name
+		// Необязательные параметры хранятся указателями, печатаем их значения
+		if field.Kind() == reflect.Pointer && !field.IsNil() {
+			field = field.Elem()
+		}
 		fmt.Printf("  %s: %v\n", fieldName, field.Interface())
 	}
 }
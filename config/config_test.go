@@ -0,0 +1,43 @@
+package config
+
+import "testing"
+
+func TestParseParameter(t *testing.T) {
+	tests := []struct {
+		name    string
+		param   string
+		in      string
+		want    float64
+		wantErr bool
+	}{
+		{"temperature", "temperature", "0.7", 0.7, false},
+		{"explicit zero", "temperature", "0", 0, false},
+		{"upper bound", "temperature", "2", 2, false},
+		{"above range", "temperature", "2.1", 0, true},
+		{"below range", "top_p", "-0.1", 0, true},
+		{"negative penalty", "frequency_penalty", "-2", -2, false},
+		{"penalty above range", "presence_penalty", "3", 0, true},
+		{"nan", "temperature", "nan", 0, true},
+		{"NaN", "top_p", "NaN", 0, true},
+		{"inf", "temperature", "inf", 0, true},
+		{"negative infinity", "frequency_penalty", "-Inf", 0, true},
+		{"not a number", "temperature", "hot", 0, true},
+		{"top_k integer", "top_k", "40", 40, false},
+		{"top_k fraction", "top_k", "40.5", 0, true},
+		{"seed negative", "seed", "-7", -7, false},
+		{"seed fraction", "seed", "1.5", 0, true},
+		{"seed too large", "seed", "1e20", 0, true},
+		{"unknown", "warmth", "1", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseParameter(tt.param, tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseParameter(%q, %q) error = %v, wantErr %v", tt.param, tt.in, err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("ParseParameter(%q, %q) = %v, want %v", tt.param, tt.in, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Persona is a named preset of system prompt and model settings.
+type Persona struct {
+	Name        string   `mapstructure:"name" json:"name"`
+	Prompt      string   `mapstructure:"prompt" json:"prompt"`
+	Model       string   `mapstructure:"model" json:"model,omitempty"`
+	Temperature *float64 `mapstructure:"temperature" json:"temperature,omitempty"`
+	MaxTokens   int      `mapstructure:"max_tokens" json:"max_tokens,omitempty"`
+}
+
+// Имя персоны попадает в callback data, ограниченную 64 байтами
+var personaNameRe = regexp.MustCompile(`^[\p{L}\p{N}_-]+$`)
+
+const personaNameLimit = 48
+
+// ValidPersonaName reports whether the name fits into the callback data of the persona buttons.
+func ValidPersonaName(name string) bool {
+	return personaNameRe.MatchString(name) && len(name) <= personaNameLimit
+}
+
+// validPersonas drops personas from config.yaml whose names cannot be used in buttons
+// or whose temperature is out of range
+func validPersonas(personas []Persona) []Persona {
+	valid := personas[:0]
+	for _, persona := range personas {
+		if !ValidPersonaName(persona.Name) {
+			log.Printf("Skipping persona %q: the name must be up to %d bytes of letters, digits, _ and -", persona.Name, personaNameLimit)
+			continue
+		}
+		if persona.Temperature != nil {
+			if err := CheckParameter("temperature", *persona.Temperature); err != nil {
+				log.Printf("Skipping persona %q: %v", persona.Name, err)
+				continue
+			}
+		}
+		valid = append(valid, persona)
+	}
+	return valid
+}
+
+// PersonaLibrary combines personas from config.yaml with personas added by
+// admins at runtime, which are stored in a JSON file and take precedence.
+type PersonaLibrary struct {
+	path   string
+	mu     sync.RWMutex
+	custom []Persona
+}
+
+func NewPersonaLibrary(path string) (*PersonaLibrary, error) {
+	library := &PersonaLibrary{path: path}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return library, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading personas: %w", err)
+	}
+	if err := json.Unmarshal(data, &library.custom); err != nil {
+		return nil, fmt.Errorf("error unmarshalling personas: %w", err)
+	}
+	return library, nil
+}
+
+// List returns personas from the config followed by the ones added at runtime.
+func (l *PersonaLibrary) List(conf *Config) []Persona {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	list := make([]Persona, 0, len(conf.Personas)+len(l.custom))
+	for _, persona := range conf.Personas {
+		if custom, ok := findPersona(l.custom, persona.Name); ok {
+			persona = custom
+		}
+		list = append(list, persona)
+	}
+	for _, persona := range l.custom {
+		if _, ok := findPersona(conf.Personas, persona.Name); !ok {
+			list = append(list, persona)
+		}
+	}
+	return list
+}
+
+// Get returns the persona with the given name.
+func (l *PersonaLibrary) Get(conf *Config, name string) (Persona, bool) {
+	return findPersona(l.List(conf), name)
+}
+
+// Save adds or replaces a runtime persona and writes the library to disk.
+func (l *PersonaLibrary) Save(persona Persona) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	replaced := false
+	for i := range l.custom {
+		if strings.EqualFold(l.custom[i].Name, persona.Name) {
+			l.custom[i] = persona
+			replaced = true
+		}
+	}
+	if !replaced {
+		l.custom = append(l.custom, persona)
+	}
+	return l.write()
+}
+
+// Delete removes a runtime persona. Personas from config.yaml cannot be
+// deleted; deleting a runtime override restores the config version.
+func (l *PersonaLibrary) Delete(name string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for i := range l.custom {
+		if strings.EqualFold(l.custom[i].Name, name) {
+			l.custom = append(l.custom[:i], l.custom[i+1:]...)
+			return true, l.write()
+		}
+	}
+	return false, nil
+}
+
+// write saves runtime personas; the caller must hold mu
+func (l *PersonaLibrary) write() error {
+	data, err := json.MarshalIndent(l.custom, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling personas: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return fmt.Errorf("error creating personas directory: %w", err)
+	}
+	if err := os.WriteFile(l.path, data, 0644); err != nil {
+		return fmt.Errorf("error writing personas: %w", err)
+	}
+	return nil
+}
+
+func findPersona(personas []Persona, name string) (Persona, bool) {
+	for _, persona := range personas {
+		if strings.EqualFold(persona.Name, name) {
+			return persona, true
+		}
+	}
+	return Persona{}, false
+}
@@ -1,15 +1,22 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"html"
 	"log"
+	"net/http"
 	"openrouter-bot/api"
 	"openrouter-bot/config"
 	"openrouter-bot/lang"
 	"openrouter-bot/user"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/sashabaranov/go-openai"
@@ -42,7 +49,14 @@ func getBotCommands(userID int64, conf *config.Config) []tgbotapi.BotCommand {
 			{Command: "help", Description: lang.Translate("description.help", conf.Lang)},
 			{Command: "get_models", Description: lang.Translate("description.getModels", conf.Lang)},
 			{Command: "set_model", Description: lang.Translate("description.setModel", conf.Lang)},
+			{Command: "model", Description: lang.Translate("description.model", conf.Lang)},
+			{Command: "new", Description: lang.Translate("description.new", conf.Lang)},
+			{Command: "chats", Description: lang.Translate("description.chats", conf.Lang)},
 			{Command: "reset", Description: lang.Translate("description.reset", conf.Lang)},
+			{Command: "prompt", Description: lang.Translate("description.prompt", conf.Lang)},
+			{Command: "params", Description: lang.Translate("description.params", conf.Lang)},
+			{Command: "persona", Description: lang.Translate("description.persona", conf.Lang)},
+			{Command: "image", Description: lang.Translate("description.image", conf.Lang)},
 			{Command: "stats", Description: lang.Translate("description.stats", conf.Lang)},
 			{Command: "stop", Description: lang.Translate("description.stop", conf.Lang)},
 			{Command: "pirdun", Description: lang.Translate("description.pirdun", conf.Lang)},
@@ -52,8 +66,15 @@ func getBotCommands(userID int64, conf *config.Config) []tgbotapi.BotCommand {
 	if role == "user" {
 		return []tgbotapi.BotCommand{
 			{Command: "start", Description: lang.Translate("description.start", conf.Lang)},
-			{Command: "help", Description:  lang.Translate("description.helpuser", conf.Lang)},
+			{Command: "help", Description: lang.Translate("description.helpuser", conf.Lang)},
+			{Command: "model", Description: lang.Translate("description.model", conf.Lang)},
+			{Command: "new", Description: lang.Translate("description.new", conf.Lang)},
+			{Command: "chats", Description: lang.Translate("description.chats", conf.Lang)},
 			{Command: "reset", Description: lang.Translate("description.reset", conf.Lang)},
+			{Command: "prompt", Description: lang.Translate("description.prompt", conf.Lang)},
+			{Command: "params", Description: lang.Translate("description.params", conf.Lang)},
+			{Command: "persona", Description: lang.Translate("description.persona", conf.Lang)},
+			{Command: "image", Description: lang.Translate("description.image", conf.Lang)},
 			{Command: "stop", Description: lang.Translate("description.stop", conf.Lang)},
 			{Command: "pirdun", Description: lang.Translate("description.pirdun", conf.Lang)},
 		}
@@ -66,8 +87,673 @@ func getBotCommands(userID int64, conf *config.Config) []tgbotapi.BotCommand {
 	}
 }
 
+func formatModelList(models []string) string {
+	if len(models) == 0 {
+		return "—"
+	}
+	var b strings.Builder
+	for _, model := range models {
+		b.WriteString("➡ <code>" + model + "</code>\n")
+	}
+	return b.String()
+}
+
+// === Обработка нажатий на inline-кнопки ===
+func handleCallback(bot *tgbotapi.BotAPI, client *openai.Client, query *tgbotapi.CallbackQuery, conf *config.Config, userManager *user.Manager, catalog *api.ModelCatalog, personas *config.PersonaLibrary) {
+	callback := tgbotapi.NewCallback(query.ID, "")
+	defer func() {
+		if _, err := bot.Request(callback); err != nil {
+			log.Printf("Error answering callback: %v", err)
+		}
+	}()
+	if query.Message == nil {
+		return
+	}
+
+	userStats := userManager.GetUser(query.From.ID, query.From.UserName, conf)
+	history := chatHistory(query.Message.Chat, userStats, userManager, conf)
+	prefix, args := api.ParseCallbackData(query.Data)
+
+	switch prefix {
+	case api.CallbackModelsPage, api.CallbackModelDetails, api.CallbackModelUse:
+		if getUserRole(query.From.ID, conf) != "admin" {
+			callback.Text = lang.Translate("adminOnly", conf.Lang)
+			return
+		}
+		callback.Text = handleModelPicker(bot, query.Message, prefix, args, conf, history, catalog)
+
+	case api.CallbackRegenerate, api.CallbackContinue, api.CallbackRetryModels, api.CallbackRetryWith, api.CallbackAnswerBack:
+		callback.Text = handleAnswerButton(bot, client, query, prefix, args, conf, userStats, userManager, catalog)
+
+	case callbackChatSwitch:
+		if !canChangeHistory(bot, history, userStats, query.Message.Chat.ID, query.From.ID, conf) {
+			callback.Text = lang.Translate("group.adminOnly", conf.Lang)
+			return
+		}
+		if err := history.SwitchConversation(args[0]); err != nil {
+			callback.Text = lang.Translate("chats.notFound", conf.Lang)
+			return
+		}
+		callback.Text = conversationName(history.CurrentConversation(), conf)
+		list := chatsMessage(query.Message.Chat.ID, history, conf)
+		edit := tgbotapi.NewEditMessageTextAndMarkup(query.Message.Chat.ID, query.Message.MessageID, list.Text, list.ReplyMarkup.(tgbotapi.InlineKeyboardMarkup))
+		edit.ParseMode = tgbotapi.ModeHTML
+		bot.Send(edit)
+
+	case callbackPersona:
+		if !canChangeHistory(bot, history, userStats, query.Message.Chat.ID, query.From.ID, conf) {
+			callback.Text = lang.Translate("group.adminOnly", conf.Lang)
+			return
+		}
+		persona, ok := personas.Get(conf, args[0])
+		if !ok && args[0] != "" {
+			callback.Text = lang.Translate("personas.notFound", conf.Lang)
+			return
+		}
+		history.ApplyPersona(persona)
+		name := persona.Name
+		if name == "" {
+			name = lang.Translate("personas.default", conf.Lang)
+		}
+		callback.Text = name
+		edit := tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID,
+			fmt.Sprintf(lang.Translate("personas.set", conf.Lang), html.EscapeString(name), html.EscapeString(history.GetModel(conf))))
+		edit.ParseMode = tgbotapi.ModeHTML
+		bot.Send(edit)
+
+	case callbackGroupSetting:
+		chat := query.Message.Chat
+		if chat.Type == "private" {
+			return
+		}
+		if getUserRole(query.From.ID, conf) != "admin" && !isChatAdmin(bot, chat.ID, query.From.ID) {
+			callback.Text = lang.Translate("group.adminOnly", conf.Lang)
+			return
+		}
+		groupStats := userManager.GetUser(chat.ID, chat.Title, conf)
+		settings := groupStats.GetGroupSettings(conf)
+		switch args[0] {
+		case "m":
+			settings.Mention = !settings.Mention
+		case "r":
+			settings.Reply = !settings.Reply
+		case "h":
+			settings.SharedHistory = !settings.SharedHistory
+		default:
+			return
+		}
+		groupStats.SetGroupSettings(settings)
+		msg := groupSettingsMessage(chat.ID, settings, conf)
+		edit := tgbotapi.NewEditMessageTextAndMarkup(chat.ID, query.Message.MessageID, msg.Text, msg.ReplyMarkup.(tgbotapi.InlineKeyboardMarkup))
+		edit.ParseMode = tgbotapi.ModeHTML
+		bot.Send(edit)
+
+	case api.CallbackModelSet:
+		if getUserRole(query.From.ID, conf) != "admin" {
+			callback.Text = lang.Translate("adminOnly", conf.Lang)
+			return
+		}
+		if !catalog.Has(args[0]) {
+			callback.Text = lang.Translate("picker.notFound", conf.Lang)
+			return
+		}
+		history.SetModel(args[0])
+		edit := tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID,
+			fmt.Sprintf(lang.Translate("commands.modelSet", conf.Lang), args[0]))
+		edit.ParseMode = tgbotapi.ModeHTML
+		bot.Send(edit)
+		callback.Text = args[0]
+	}
+}
+
+// === Навигация по списку моделей и выбор модели ===
+func handleModelPicker(bot *tgbotapi.BotAPI, message *tgbotapi.Message, prefix string, args []string, conf *config.Config, userStats *user.UsageTracker, catalog *api.ModelCatalog) string {
+	if len(args) < 2 {
+		return ""
+	}
+	models := catalog.Models()
+	if len(models) == 0 {
+		return lang.Translate("errorText", conf.Lang)
+	}
+
+	filter := args[0]
+	filtered := api.FilterModels(models, filter)
+	var text string
+	var keyboard tgbotapi.InlineKeyboardMarkup
+
+	switch prefix {
+	case api.CallbackModelsPage:
+		page, _ := strconv.Atoi(args[1])
+		text, keyboard = api.ModelsPage(models, filter, page, conf.Lang)
+
+	case api.CallbackModelDetails:
+		if len(args) < 3 {
+			return ""
+		}
+		page, _ := strconv.Atoi(args[1])
+		// ID модели может содержать двоеточие, поэтому он собирается из оставшихся частей
+		index, ok := api.FindModel(filtered, strings.Join(args[2:], ":"))
+		if !ok {
+			return lang.Translate("picker.notFound", conf.Lang)
+		}
+		text, keyboard = api.ModelDetails(filtered[index], filter, page, index, conf.Lang)
+
+	case api.CallbackModelUse:
+		index, ok := api.FindModel(filtered, strings.Join(args[1:], ":"))
+		if !ok {
+			return lang.Translate("picker.notFound", conf.Lang)
+		}
+		userStats.SetModel(filtered[index].ID)
+		text = fmt.Sprintf(lang.Translate("commands.modelSet", conf.Lang), filtered[index].ID)
+		edit := tgbotapi.NewEditMessageText(message.Chat.ID, message.MessageID, text)
+		edit.ParseMode = tgbotapi.ModeHTML
+		bot.Send(edit)
+		return filtered[index].ID
+	}
+
+	edit := tgbotapi.NewEditMessageTextAndMarkup(message.Chat.ID, message.MessageID, text, keyboard)
+	edit.ParseMode = tgbotapi.ModeHTML
+	if _, err := bot.Send(edit); err != nil {
+		log.Printf("Error editing model picker: %v", err)
+	}
+	return ""
+}
+
+// === Кнопки под ответом: повторить, продолжить, повторить с другой моделью ===
+func handleAnswerButton(bot *tgbotapi.BotAPI, client *openai.Client, query *tgbotapi.CallbackQuery, prefix string, args []string, conf *config.Config, userStats *user.UsageTracker, userManager *user.Manager, catalog *api.ModelCatalog) string {
+	message := query.Message
+	history := chatHistory(message.Chat, userStats, userManager, conf)
+
+	switch prefix {
+	case api.CallbackRetryModels, api.CallbackAnswerBack:
+		truncated := args[0] == "1"
+		keyboard := api.AnswerKeyboard(truncated, len(conf.AllowedModels) > 0, conf.Lang)
+		if prefix == api.CallbackRetryModels {
+			keyboard = api.RetryModelsKeyboard(conf.AllowedModels, history.GetModel(conf), truncated, conf.Lang)
+		}
+		if _, err := bot.Send(tgbotapi.NewEditMessageReplyMarkup(message.Chat.ID, message.MessageID, keyboard)); err != nil {
+			log.Printf("Error editing answer buttons: %v", err)
+		}
+		return ""
+	}
+
+	// Повторить или продолжить можно только последний ответ в истории
+	if !history.IsLastTurn(message.Chat.ID, message.MessageID) {
+		return lang.Translate("buttons.notLast", conf.Lang)
+	}
+	if !userStats.HaveAccess(conf) {
+		return lang.Translate("budget_out", conf.Lang)
+	}
+
+	if prefix == api.CallbackContinue {
+		// Продолжение придёт новым сообщением, кнопки оборванного ответа больше не нужны
+		noButtons := tgbotapi.InlineKeyboardMarkup{InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{}}
+		bot.Send(tgbotapi.NewEditMessageReplyMarkup(message.Chat.ID, message.MessageID, noButtons))
+		request := &tgbotapi.Message{From: query.From, Chat: message.Chat, Text: api.ContinuePrompt}
+		go processMessage(bot, client, api.ChatRequest{Message: request}, conf, userStats, history, catalog)
+		return ""
+	}
+
+	text := ""
+	if prefix == api.CallbackRetryWith {
+		// Модель общей истории группы меняют только её администраторы
+		if !canChangeHistory(bot, history, userStats, message.Chat.ID, query.From.ID, conf) {
+			return lang.Translate("group.adminOnly", conf.Lang)
+		}
+		index, err := strconv.Atoi(args[0])
+		if err != nil || index < 0 || index >= len(conf.AllowedModels) {
+			return lang.Translate("picker.notFound", conf.Lang)
+		}
+		text = conf.AllowedModels[index]
+	}
+
+	// Последний ход убирается из истории и задаётся заново; новый ответ заменит прежний
+	turn, answerIDs, ok := history.TakeLastTurn(message.Chat.ID, message.MessageID)
+	if !ok {
+		return lang.Translate("buttons.notLast", conf.Lang)
+	}
+	// Модель меняется, только когда повтор действительно начинается
+	if text != "" {
+		history.SetModel(text)
+	}
+	request := &tgbotapi.Message{From: query.From, Chat: message.Chat, Text: turn.Content}
+	if len(turn.MessageIDs) > 0 {
+		request.MessageID = turn.MessageIDs[0]
+	}
+	go processMessage(bot, client, api.ChatRequest{Message: request, Photos: turn.Images, AnswerIDs: answerIDs}, conf, userStats, history, catalog)
+	return text
+}
+
+// === История, в которой бот отвечает в чате: общая история группы или история участника ===
+func chatHistory(chat *tgbotapi.Chat, userStats *user.UsageTracker, userManager *user.Manager, conf *config.Config) *user.UsageTracker {
+	if chat.Type == "private" {
+		return userStats
+	}
+	if userManager.GroupSettings(chat.ID, chat.Title, conf).SharedHistory {
+		return userManager.GetUser(chat.ID, chat.Title, conf)
+	}
+	return userStats
+}
+
+// canChangeHistory reports whether the user may change the conversations and
+// model settings of history: in a group with shared history only group and bot admins may.
+func canChangeHistory(bot *tgbotapi.BotAPI, history, userStats *user.UsageTracker, chatID, userID int64, conf *config.Config) bool {
+	return history == userStats || getUserRole(userID, conf) == "admin" || isChatAdmin(bot, chatID, userID)
+}
+
+// === Сообщение о неизвестной модели с кнопками похожих моделей ===
+func unknownModelMessage(chatID int64, model string, conf *config.Config, catalog *api.ModelCatalog) tgbotapi.MessageConfig {
+	suggestions := catalog.Suggest(model, 5)
+	text := fmt.Sprintf(lang.Translate("commands.unknownModel", conf.Lang), html.EscapeString(model))
+	if len(suggestions) > 0 {
+		text += "\n\n" + lang.Translate("commands.suggestModels", conf.Lang)
+	}
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ParseMode = tgbotapi.ModeHTML
+	if len(suggestions) > 0 {
+		msg.ReplyMarkup = api.ModelSuggestions(suggestions)
+	}
+	return msg
+}
+
+// Префиксы callback data кнопок переключения разговора и персоны
+const (
+	callbackChatSwitch   = "cs"
+	callbackPersona      = "ps"
+	callbackGroupSetting = "gs"
+)
+
+// === Список персон с кнопками выбора ===
+func personasMessage(chatID int64, userStats *user.UsageTracker, conf *config.Config, personas *config.PersonaLibrary) tgbotapi.MessageConfig {
+	current := userStats.CurrentConversation().Persona
+	list := personas.List(conf)
+	if len(list) == 0 {
+		return tgbotapi.NewMessage(chatID, lang.Translate("personas.empty", conf.Lang))
+	}
+
+	mark := func(name string) string {
+		if strings.EqualFold(name, current) {
+			return "✅ "
+		}
+		return ""
+	}
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, persona := range list {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(mark(persona.Name)+persona.Name, callbackPersona+":"+persona.Name),
+		))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(mark("")+lang.Translate("personas.default", conf.Lang), callbackPersona+":"),
+	))
+
+	msg := tgbotapi.NewMessage(chatID, lang.Translate("personas.choose", conf.Lang))
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	return msg
+}
+
+// === Разбор описания персоны из /persona_set ===
+// Первая строка — имя, далее строки "model:", "temperature:", "max_tokens:"
+// и "prompt:", после которой весь оставшийся текст считается промптом.
+func parsePersona(text string, base config.Persona) (config.Persona, error) {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	persona := base
+	persona.Name = strings.TrimSpace(lines[0])
+	if !config.ValidPersonaName(persona.Name) {
+		return persona, fmt.Errorf("invalid persona name %q", persona.Name)
+	}
+
+	for i := 1; i < len(lines); i++ {
+		key, value, found := strings.Cut(lines[i], ":")
+		if !found {
+			return persona, fmt.Errorf("invalid line %q", lines[i])
+		}
+		value = strings.TrimSpace(value)
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "prompt":
+			persona.Prompt = strings.TrimSpace(strings.Join(append([]string{value}, lines[i+1:]...), "\n"))
+			i = len(lines)
+		case "model":
+			persona.Model = value
+		case "temperature":
+			temperature, err := config.ParseParameter("temperature", value)
+			if err != nil {
+				return persona, err
+			}
+			persona.Temperature = &temperature
+		case "max_tokens":
+			maxTokens, err := strconv.Atoi(value)
+			if err != nil {
+				return persona, err
+			}
+			persona.MaxTokens = maxTokens
+		default:
+			return persona, fmt.Errorf("unknown field %q", key)
+		}
+	}
+	if persona.Prompt == "" {
+		return persona, fmt.Errorf("persona %q has no prompt", persona.Name)
+	}
+	return persona, nil
+}
+
+// === Список разговоров пользователя с кнопками переключения ===
+func chatsMessage(chatID int64, userStats *user.UsageTracker, conf *config.Config) tgbotapi.MessageConfig {
+	current := userStats.CurrentConversation()
+	var text strings.Builder
+	var rows [][]tgbotapi.InlineKeyboardButton
+	text.WriteString(lang.Translate("chats.list", conf.Lang))
+	for i, conv := range userStats.Conversations() {
+		name := conversationName(conv, conf)
+		model := conv.ActiveModel()
+		if model == "" {
+			model = conf.Model.ModelNameDefault
+		}
+		mark := ""
+		if conv.ID == current.ID {
+			mark = "✅ "
+		}
+		text.WriteString(fmt.Sprintf("%d. %s<b>%s</b> — <code>%s</code>\n", i+1, mark, html.EscapeString(name), html.EscapeString(model)))
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(mark+name, callbackChatSwitch+":"+conv.ID),
+		))
+	}
+	msg := tgbotapi.NewMessage(chatID, text.String())
+	msg.ParseMode = tgbotapi.ModeHTML
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	return msg
+}
+
+func conversationName(conv user.Conversation, conf *config.Config) string {
+	if conv.ID == user.DefaultConversation {
+		return lang.Translate("chats.default", conf.Lang)
+	}
+	return conv.Name
+}
+
+// === Является ли участник администратором группы ===
+func isChatAdmin(bot *tgbotapi.BotAPI, chatID, userID int64) bool {
+	member, err := bot.GetChatMember(tgbotapi.GetChatMemberConfig{
+		ChatConfigWithUser: tgbotapi.ChatConfigWithUser{ChatID: chatID, UserID: userID},
+	})
+	if err != nil {
+		log.Printf("Error getting chat member %d in chat %d: %v", userID, chatID, err)
+		return false
+	}
+	return member.IsCreator() || member.IsAdministrator()
+}
+
+// === Настройки группы с кнопками переключения ===
+func groupSettingsMessage(chatID int64, settings user.GroupSettings, conf *config.Config) tgbotapi.MessageConfig {
+	button := func(enabled bool, key, setting string) tgbotapi.InlineKeyboardButton {
+		mark := "❌ "
+		if enabled {
+			mark = "✅ "
+		}
+		return tgbotapi.NewInlineKeyboardButtonData(mark+lang.Translate("group."+key, conf.Lang), callbackGroupSetting+":"+setting)
+	}
+	msg := tgbotapi.NewMessage(chatID, lang.Translate("group.settings", conf.Lang))
+	msg.ParseMode = tgbotapi.ModeHTML
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(button(settings.Mention, "mention", "m")),
+		tgbotapi.NewInlineKeyboardRow(button(settings.Reply, "reply", "r")),
+		tgbotapi.NewInlineKeyboardRow(button(settings.SharedHistory, "shared", "h")),
+	)
+	return msg
+}
+
+// === Обращаются ли к боту в группе ===
+// Возвращает текст сообщения (или подпись к фото) без упоминания бота.
+func groupTrigger(bot *tgbotapi.BotAPI, message *tgbotapi.Message, settings user.GroupSettings) (string, bool) {
+	text := message.Text
+	if text == "" {
+		text = message.Caption
+	}
+	mention := regexp.MustCompile(`(?i)@` + regexp.QuoteMeta(bot.Self.UserName) + `\b`)
+	if settings.Mention && mention.MatchString(text) {
+		return strings.TrimSpace(mention.ReplaceAllString(text, "")), true
+	}
+	reply := message.ReplyToMessage
+	if settings.Reply && reply != nil && reply.From != nil && reply.From.ID == bot.Self.ID {
+		return text, true
+	}
+	return text, false
+}
+
+// === Запрос к модели по обычному сообщению; в группе — только если обращаются к боту ===
+// Возвращает и историю, в которой бот ответит. Трекер группы с файлом расходов
+// создаётся только после того, как к боту обратились.
+func newChatRequest(bot *tgbotapi.BotAPI, message *tgbotapi.Message, quote string, userStats *user.UsageTracker, userManager *user.Manager, conf *config.Config) (api.ChatRequest, *user.UsageTracker, bool) {
+	history := userStats
+	if message.Chat.Type != "private" {
+		settings := userManager.GroupSettings(message.Chat.ID, message.Chat.Title, conf)
+		text, ok := groupTrigger(bot, message, settings)
+		if !ok {
+			return api.ChatRequest{}, nil, false
+		}
+		groupMsg := *message
+		groupMsg.Text = text
+		message = &groupMsg
+		history = chatHistory(message.Chat, userStats, userManager, conf)
+	}
+	request := api.ChatRequest{Message: message, Quote: quote}
+	if history != userStats {
+		request.Author = api.AuthorName(message.From)
+	}
+	return request, history, true
+}
+
+// === Команда адресована другому боту (/cmd@other_bot) ===
+func commandForOtherBot(bot *tgbotapi.BotAPI, message *tgbotapi.Message) bool {
+	_, target, found := strings.Cut(message.CommandWithAt(), "@")
+	return found && !strings.EqualFold(target, bot.Self.UserName)
+}
+
 var commandsSent sync.Map
 
+// Сколько символов расшифровки показывать пользователю (лимит сообщения Telegram — 4096)
+const transcriptPreviewLimit = 4000
+
+// === Распознавание голосового сообщения ===
+// Распознанный текст отправляется ответом на голосовое и дополняет подпись к нему.
+func transcribeMessage(ctx context.Context, bot *tgbotapi.BotAPI, message *tgbotapi.Message, conf *config.Config) (*tgbotapi.Message, bool) {
+	transcript, err := api.TranscribeAudio(ctx, bot, conf, message)
+	var text string
+	switch {
+	case errors.Is(err, api.ErrTranscriptionDisabled):
+		text = lang.Translate("voice.disabled", conf.Lang)
+	case ctx.Err() != nil:
+		text = lang.Translate("commands.stop", conf.Lang)
+	case err != nil:
+		log.Printf("Error transcribing voice message: %v", err)
+		text = lang.Translate("voice.error", conf.Lang)
+	case transcript == "":
+		text = lang.Translate("voice.empty", conf.Lang)
+	default:
+		// Длинную расшифровку показываем частично, модели уходит полный текст
+		shown := []rune(transcript)
+		if len(shown) > transcriptPreviewLimit {
+			shown = append(shown[:transcriptPreviewLimit], '…')
+		}
+		text = fmt.Sprintf(lang.Translate("voice.recognized", conf.Lang), string(shown))
+	}
+	reply := tgbotapi.NewMessage(message.Chat.ID, text)
+	reply.ReplyToMessageID = message.MessageID
+	if _, err := bot.Send(reply); err != nil {
+		log.Printf("Error sending transcript: %v", err)
+	}
+	if err != nil || transcript == "" {
+		return nil, false
+	}
+
+	caption := message.Text
+	if caption == "" {
+		caption = message.Caption
+	}
+	voice := *message
+	voice.Text = strings.TrimSpace(caption + "\n\n" + transcript)
+	return &voice, true
+}
+
+// === Текст прикреплённого файла с подписью к нему ===
+func documentMessage(ctx context.Context, bot *tgbotapi.BotAPI, message *tgbotapi.Message, conf *config.Config, catalog *api.ModelCatalog, model string) (*tgbotapi.Message, bool) {
+	caption := message.Text
+	if caption == "" {
+		caption = message.Caption
+	}
+	prompt, err := api.DocumentPrompt(ctx, bot, conf, catalog, model, message, caption)
+	if err != nil {
+		text := lang.Translate("document.error", conf.Lang)
+		switch {
+		case errors.Is(err, api.ErrUnsupportedDocument):
+			text = lang.Translate("document.unsupported", conf.Lang)
+		case errors.Is(err, api.ErrFileTooLarge):
+			text = lang.Translate("document.tooLarge", conf.Lang)
+		case ctx.Err() != nil:
+			text = lang.Translate("commands.stop", conf.Lang)
+		default:
+			log.Printf("Error reading document: %v", err)
+		}
+		reply := tgbotapi.NewMessage(message.Chat.ID, text)
+		reply.ReplyToMessageID = message.MessageID
+		bot.Send(reply)
+		return nil, false
+	}
+
+	withDocument := *message
+	withDocument.Text = prompt
+	return &withDocument, true
+}
+
+// === Обработка запроса к модели в отменяемом через /stop контексте ===
+// Расходы записываются на userStats, история и настройки модели берутся из history:
+// в группе с общей историей это история чата.
+func processMessage(bot *tgbotapi.BotAPI, client *openai.Client, request api.ChatRequest, conf *config.Config, userStats, history *user.UsageTracker, catalog *api.ModelCatalog) {
+	if !userStats.HaveAccess(conf) {
+		bot.Send(tgbotapi.NewMessage(request.Message.Chat.ID, lang.Translate("budget_out", conf.Lang)))
+		return
+	}
+
+	req := userStats.StartRequest(context.Background())
+	defer req.Done()
+
+	// Голосовое сообщение сначала распознаётся и показывается пользователю
+	if api.HasAudio(request.Message) {
+		req.SetStage(user.StageTranscription)
+		message, ok := transcribeMessage(req.Ctx, bot, request.Message, conf)
+		if !ok {
+			return
+		}
+		request.Message = message
+		req.SetStage(user.StageGeneration)
+	}
+
+	// Текст прикреплённого файла становится частью запроса
+	if request.Message.Document != nil {
+		req.SetStage(user.StageDocument)
+		message, ok := documentMessage(req.Ctx, bot, request.Message, conf, catalog, history.GetModel(conf))
+		if !ok {
+			return
+		}
+		request.Message = message
+		req.SetStage(user.StageGeneration)
+	}
+
+	// Ответ на старый ответ бота откатывает историю; общую историю группы так меняют только админы
+	if request.Message.ReplyToMessage != nil && request.Message.From != nil {
+		request.Rewind = canChangeHistory(bot, history, userStats, request.Message.Chat.ID, request.Message.From.ID, conf)
+	}
+
+	model := request.ModelFor(conf, history)
+	if len(request.PhotoIDs()) > 0 && !api.AcceptsImages(catalog, conf, model) {
+		sendVisionUnsupported(bot, request.Message, model, conf, catalog)
+		return
+	}
+	responseID, usage := api.HandleChatGPTStreamResponse(req.Ctx, bot, client, request, conf, history, userStats, catalog)
+	if responseID == "" && usage == nil {
+		return
+	}
+	// Остановленная генерация всё равно оплачивается провайдером, поэтому стоимость
+	// запрашивается в отдельном запросе, который /stop отменяет только на этапе usage
+	req.Done()
+	billing := userStats.StartRequest(context.Background())
+	defer billing.Done()
+	billing.SetStage(user.StageUsage)
+	if err := api.RecordCost(billing.Ctx, userStats, conf, catalog, model, responseID, usage); err != nil {
+		log.Printf("Error recording cost for user %s: %v", userStats.UserID, err)
+	}
+}
+
+// handleParams показывает параметры сэмплирования разговора или меняет один из них:
+// /params, /params top_k 40, /params top_k default, /params reset
+func handleParams(args []string, conf *config.Config, history *user.UsageTracker) string {
+	switch {
+	case len(args) == 0:
+		return paramsMessage(conf, history)
+	case len(args) == 1 && args[0] == "reset":
+		history.ResetParameters()
+		return lang.Translate("params.reset", conf.Lang)
+	case len(args) != 2:
+		return lang.Translate("params.usage", conf.Lang)
+	}
+
+	name := strings.ToLower(args[0])
+	if !slices.Contains(config.SamplingParameters, name) {
+		return fmt.Sprintf(lang.Translate("params.unknown", conf.Lang), html.EscapeString(name), strings.Join(config.SamplingParameters, ", "))
+	}
+	if args[1] == "default" {
+		history.SetParameter(name, nil)
+		return fmt.Sprintf(lang.Translate("params.default", conf.Lang), name)
+	}
+	value, err := config.ParseParameter(name, args[1])
+	if err != nil {
+		limits := config.ParameterRanges[name]
+		key := "params.invalid"
+		if limits.Integer {
+			key = "params.invalidInteger"
+		}
+		return fmt.Sprintf(lang.Translate(key, conf.Lang), name, strconv.FormatFloat(limits.Min, 'f', -1, 64), strconv.FormatFloat(limits.Max, 'f', -1, 64))
+	}
+	history.SetParameter(name, &value)
+	return fmt.Sprintf(lang.Translate("params.set", conf.Lang), name, strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+// paramsMessage перечисляет действующие параметры сэмплирования и отмечает заданные для разговора
+func paramsMessage(conf *config.Config, history *user.UsageTracker) string {
+	params := api.ModelParameters(conf, history)
+	custom := history.GetParameters()
+
+	var b strings.Builder
+	b.WriteString(lang.Translate("params.title", conf.Lang) + "\n\n")
+	for _, name := range config.SamplingParameters {
+		value := "—"
+		if v, ok := params.Get(name); ok {
+			value = strconv.FormatFloat(v, 'g', -1, 64)
+		}
+		if _, ok := custom[name]; ok {
+			value += " " + lang.Translate("params.custom", conf.Lang)
+		}
+		fmt.Fprintf(&b, "<code>%s</code>: %s\n", name, value)
+	}
+	b.WriteString("\n" + lang.Translate("params.usage", conf.Lang))
+	return b.String()
+}
+
+// sendVisionUnsupported отвечает на фото для модели без поддержки изображений
+// и предлагает разрешённые модели, которые их принимают
+func sendVisionUnsupported(bot *tgbotapi.BotAPI, message *tgbotapi.Message, model string, conf *config.Config, catalog *api.ModelCatalog) {
+	text := fmt.Sprintf(lang.Translate("vision.unsupported", conf.Lang), html.EscapeString(model))
+	if models := api.VisionModels(catalog, conf.AllowedModels); len(models) > 0 {
+		text += "\n\n" + fmt.Sprintf(lang.Translate("vision.suggest", conf.Lang), formatModelList(models))
+	} else if message.From != nil && getUserRole(message.From.ID, conf) == "admin" {
+		text += "\n\n" + lang.Translate("vision.picker", conf.Lang)
+	}
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	msg.ParseMode = tgbotapi.ModeHTML
+	msg.ReplyToMessageID = message.MessageID
+	bot.Send(msg)
+}
+
 func main() {
 	err := lang.LoadTranslations("./lang/")
 	if err != nil {
@@ -101,17 +787,55 @@ func main() {
 		log.Printf("Не удалось установить глобальные команды: %v", err)
 	}
 
+	// Администраторам групп дополнительно видны настройки группы
+	groupAdminCommands := []tgbotapi.BotCommand{
+		{Command: "pirdun", Description: lang.Translate("description.pirdun", conf.Lang)},
+		{Command: "group", Description: lang.Translate("description.group", conf.Lang)},
+		{Command: "reset", Description: lang.Translate("description.reset", conf.Lang)},
+	}
+	_, err = bot.Request(tgbotapi.NewSetMyCommandsWithScope(tgbotapi.NewBotCommandScopeAllChatAdministrators(), groupAdminCommands...))
+	if err != nil {
+		log.Printf("Не удалось установить команды администраторов групп: %v", err)
+	}
+
 	u := tgbotapi.NewUpdate(0)
 	u.Timeout = 60
-	updates := bot.GetUpdatesChan(u)
+	updates := getUpdatesChan(bot, u)
 
 	clientOptions := openai.DefaultConfig(conf.OpenAIApiKey)
 	clientOptions.BaseURL = conf.OpenAIBaseURL
+	clientOptions.HTTPClient = api.ParamsClient{Client: &http.Client{}}
 	client := openai.NewClientWithConfig(clientOptions)
 
-	userManager := user.NewUserManager("logs")
+	historyStore, err := user.NewHistoryStore(conf, "logs")
+	if err != nil {
+		log.Fatalf("Error initializing history store: %v", err)
+	}
+	defer historyStore.Close()
+	userManager := user.NewUserManager("logs", historyStore)
+
+	personas, err := config.NewPersonaLibrary("logs/personas.json")
+	if err != nil {
+		log.Fatalf("Error loading personas: %v", err)
+	}
+
+	albums := newAlbumCollector()
+
+	catalog := api.NewModelCatalog(conf.OpenAIBaseURL, conf.OpenAIApiKey, time.Duration(conf.ModelsRefreshInterval)*time.Minute)
+	go catalog.Run(context.Background())
 
 	for update := range updates {
+		if update.CallbackQuery != nil {
+			handleCallback(bot, client, update.CallbackQuery, conf, userManager, catalog, personas)
+			continue
+		}
+
+		// Правка сообщения обрабатывается как новое сообщение, но заменяет свой ход в истории
+		edited := update.Message == nil && update.EditedMessage != nil
+		if edited {
+			update.Message = update.EditedMessage
+		}
+
 		if update.Message == nil {
 			continue
 		}
@@ -138,10 +862,15 @@ func main() {
 		}
 
 		if update.Message.IsCommand() {
+			if edited || commandForOtherBot(bot, update.Message) {
+				continue
+			}
+			// В группе с общей историей команды относятся к истории чата, а не участника
+			history := chatHistory(update.Message.Chat, userStats, userManager, conf)
 			cmd := update.Message.Command()
 
 			// Админские команды
-			if cmd == "get_models" || cmd == "set_model" || cmd == "stats" {
+			if cmd == "get_models" || cmd == "set_model" || cmd == "stats" || cmd == "persona_set" || cmd == "persona_del" {
 				if role != "admin" {
 					msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Эта команда доступна только администраторам.")
 					bot.Send(msg)
@@ -166,18 +895,198 @@ func main() {
 				bot.Send(msg)
 
 			case "reset":
-				userStats.ClearHistory()
-				userStats.SystemPrompt = conf.SystemPrompt
-				bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, lang.Translate("commands.reset", conf.Lang)))
+				// Общую историю группы сбрасывают только её администраторы
+				if !canChangeHistory(bot, history, userStats, update.Message.Chat.ID, userID, conf) {
+					bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, lang.Translate("group.adminOnly", conf.Lang)))
+					continue
+				}
+				args := strings.TrimSpace(update.Message.CommandArguments())
+				history.ClearHistory()
+				text := lang.Translate("commands.reset", conf.Lang)
+				switch args {
+				case "":
+				case "system":
+					history.SetSystemPrompt("")
+					text = lang.Translate("commands.reset_system", conf.Lang)
+				default:
+					history.SetSystemPrompt(args)
+					text = lang.Translate("commands.reset_prompt", conf.Lang) + args
+				}
+				bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, text))
+
+			case "prompt":
+				kind := lang.Translate("commands.promptDefault", conf.Lang)
+				if history.CurrentConversation().SystemPrompt != "" {
+					kind = lang.Translate("commands.promptCustom", conf.Lang)
+				}
+				text := fmt.Sprintf(lang.Translate("commands.prompt", conf.Lang), kind, html.EscapeString(history.GetSystemPrompt(conf)))
+				msg := tgbotapi.NewMessage(update.Message.Chat.ID, text)
+				msg.ParseMode = tgbotapi.ModeHTML
+				bot.Send(msg)
+
+			case "params":
+				args := strings.Fields(update.Message.CommandArguments())
+				if len(args) > 0 && !canChangeHistory(bot, history, userStats, update.Message.Chat.ID, userID, conf) {
+					bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, lang.Translate("group.adminOnly", conf.Lang)))
+					continue
+				}
+				msg := tgbotapi.NewMessage(update.Message.Chat.ID, handleParams(args, conf, history))
+				msg.ParseMode = tgbotapi.ModeHTML
+				bot.Send(msg)
 
 			case "stop":
-				if userStats.CurrentStream != nil {
-					userStats.CurrentStream.Close()
-					bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, lang.Translate("commands.stop", conf.Lang)))
+				stages := userStats.StopRequests()
+				if len(stages) > 0 {
+					names := make([]string, 0, len(stages))
+					for _, stage := range stages {
+						names = append(names, lang.Translate("stage."+stage, conf.Lang))
+					}
+					text := fmt.Sprintf(lang.Translate("commands.stopped", conf.Lang), len(stages), strings.Join(names, ", "))
+					bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, text))
 				} else {
 					bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, lang.Translate("commands.stop_err", conf.Lang)))
 				}
 
+			case "model":
+				args := strings.TrimSpace(update.Message.CommandArguments())
+				if args != "" && !canChangeHistory(bot, history, userStats, update.Message.Chat.ID, userID, conf) {
+					bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, lang.Translate("group.adminOnly", conf.Lang)))
+					continue
+				}
+				var text string
+				switch {
+				case args == "":
+					text = fmt.Sprintf(lang.Translate("commands.model", conf.Lang), history.GetModel(conf), formatModelList(conf.AllowedModels))
+				case args == "default":
+					history.SetModel("")
+					text = fmt.Sprintf(lang.Translate("commands.modelSet", conf.Lang), history.GetModel(conf))
+				case role == "admin" && catalog.Loaded() && !catalog.Has(args):
+					bot.Send(unknownModelMessage(update.Message.Chat.ID, args, conf, catalog))
+					continue
+				case role == "admin" || slices.Contains(conf.AllowedModels, args):
+					history.SetModel(args)
+					text = fmt.Sprintf(lang.Translate("commands.modelSet", conf.Lang), html.EscapeString(args))
+				default:
+					text = fmt.Sprintf(lang.Translate("commands.modelNotAllowed", conf.Lang), formatModelList(conf.AllowedModels))
+				}
+				msg := tgbotapi.NewMessage(update.Message.Chat.ID, text)
+				msg.ParseMode = tgbotapi.ModeHTML
+				bot.Send(msg)
+
+			case "new":
+				if !canChangeHistory(bot, history, userStats, update.Message.Chat.ID, userID, conf) {
+					bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, lang.Translate("group.adminOnly", conf.Lang)))
+					continue
+				}
+				conv, err := history.NewConversation(strings.TrimSpace(update.Message.CommandArguments()))
+				text := fmt.Sprintf(lang.Translate("chats.created", conf.Lang), html.EscapeString(conv.Name))
+				if err != nil {
+					text = lang.Translate("chats.exists", conf.Lang)
+				}
+				msg := tgbotapi.NewMessage(update.Message.Chat.ID, text)
+				msg.ParseMode = tgbotapi.ModeHTML
+				bot.Send(msg)
+
+			case "chats":
+				bot.Send(chatsMessage(update.Message.Chat.ID, history, conf))
+
+			case "switch":
+				args := strings.TrimSpace(update.Message.CommandArguments())
+				if args == "" {
+					bot.Send(chatsMessage(update.Message.Chat.ID, history, conf))
+					continue
+				}
+				if !canChangeHistory(bot, history, userStats, update.Message.Chat.ID, userID, conf) {
+					bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, lang.Translate("group.adminOnly", conf.Lang)))
+					continue
+				}
+				text := lang.Translate("chats.notFound", conf.Lang)
+				if conv, ok := history.FindConversation(args); ok && history.SwitchConversation(conv.ID) == nil {
+					text = fmt.Sprintf(lang.Translate("chats.switched", conf.Lang), html.EscapeString(conversationName(conv, conf)), len(history.GetMessages()))
+				}
+				msg := tgbotapi.NewMessage(update.Message.Chat.ID, text)
+				msg.ParseMode = tgbotapi.ModeHTML
+				bot.Send(msg)
+
+			case "rename":
+				if !canChangeHistory(bot, history, userStats, update.Message.Chat.ID, userID, conf) {
+					bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, lang.Translate("group.adminOnly", conf.Lang)))
+					continue
+				}
+				args := strings.TrimSpace(update.Message.CommandArguments())
+				var text string
+				switch err := history.RenameConversation(args); {
+				case args == "":
+					text = lang.Translate("chats.renameUsage", conf.Lang)
+				case errors.Is(err, user.ErrDefaultConversation):
+					text = lang.Translate("chats.defaultReadOnly", conf.Lang)
+				case errors.Is(err, user.ErrConversationExists):
+					text = lang.Translate("chats.exists", conf.Lang)
+				default:
+					text = fmt.Sprintf(lang.Translate("chats.renamed", conf.Lang), html.EscapeString(args))
+				}
+				msg := tgbotapi.NewMessage(update.Message.Chat.ID, text)
+				msg.ParseMode = tgbotapi.ModeHTML
+				bot.Send(msg)
+
+			case "delete":
+				if !canChangeHistory(bot, history, userStats, update.Message.Chat.ID, userID, conf) {
+					bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, lang.Translate("group.adminOnly", conf.Lang)))
+					continue
+				}
+				args := strings.TrimSpace(update.Message.CommandArguments())
+				conv, ok := history.CurrentConversation(), true
+				if args != "" {
+					conv, ok = history.FindConversation(args)
+				}
+				var text string
+				switch {
+				case !ok:
+					text = lang.Translate("chats.notFound", conf.Lang)
+				case history.DeleteConversation(conv.ID) != nil:
+					text = lang.Translate("chats.defaultReadOnly", conf.Lang)
+				default:
+					text = fmt.Sprintf(lang.Translate("chats.deleted", conf.Lang), html.EscapeString(conv.Name),
+						html.EscapeString(conversationName(history.CurrentConversation(), conf)))
+				}
+				msg := tgbotapi.NewMessage(update.Message.Chat.ID, text)
+				msg.ParseMode = tgbotapi.ModeHTML
+				bot.Send(msg)
+
+			case "persona":
+				bot.Send(personasMessage(update.Message.Chat.ID, history, conf, personas))
+
+			case "group":
+				if update.Message.Chat.Type == "private" {
+					bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, lang.Translate("group.onlyGroups", conf.Lang)))
+					continue
+				}
+				settings := userManager.GroupSettings(update.Message.Chat.ID, update.Message.Chat.Title, conf)
+				bot.Send(groupSettingsMessage(update.Message.Chat.ID, settings, conf))
+
+			case "image":
+				args := strings.TrimSpace(update.Message.CommandArguments())
+				if args == "" {
+					msg := tgbotapi.NewMessage(update.Message.Chat.ID, lang.Translate("image.usage", conf.Lang))
+					msg.ParseMode = tgbotapi.ModeHTML
+					bot.Send(msg)
+					continue
+				}
+				request := api.ChatRequest{Message: update.Message, Image: true}
+				if model := request.ModelFor(conf, history); catalog.Loaded() && !catalog.OutputsImages(model) {
+					msg := tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf(lang.Translate("image.unsupported", conf.Lang), html.EscapeString(model)))
+					msg.ParseMode = tgbotapi.ModeHTML
+					bot.Send(msg)
+					continue
+				}
+				imageMsg := *update.Message
+				imageMsg.Text = args
+				request.Message = &imageMsg
+				if history != userStats {
+					request.Author = api.AuthorName(update.Message.From)
+				}
+				go processMessage(bot, client, request, conf, userStats, history, catalog)
+
 			case "pirdun":
 				args := update.Message.CommandArguments()
 				if args == "" {
@@ -186,71 +1095,126 @@ func main() {
 				}
 				fakeMsg := *update.Message
 				fakeMsg.Text = args
-				go func() {
-					if userStats.HaveAccess(conf) {
-						responseID := api.HandleChatGPTStreamResponse(bot, client, &fakeMsg, conf, userStats)
-						if conf.Model.Type == "openrouter" {
-							userStats.GetUsageFromApi(responseID, conf)
-						}
-					} else {
-						bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, lang.Translate("budget_out", conf.Lang)))
-					}
-				}()
+				request := api.ChatRequest{Message: &fakeMsg, Quote: update.Quote}
+				if history != userStats {
+					request.Author = api.AuthorName(update.Message.From)
+				}
+				go processMessage(bot, client, request, conf, userStats, history, catalog)
 
-			case "get_models", "set_model", "stats":
+			case "get_models", "set_model", "stats", "persona_set", "persona_del":
 				// Эти команды уже проверены на admin выше
 				switch cmd {
 				case "get_models":
-					models, _ := api.GetFreeModels()
-					text := lang.Translate("commands.getModels", conf.Lang) + models
+					models := catalog.Models()
+					if len(models) == 0 {
+						bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, lang.Translate("errorText", conf.Lang)))
+						continue
+					}
+					text, keyboard := api.ModelsPage(models, api.FilterFree, 0, conf.Lang)
 					msg := tgbotapi.NewMessage(update.Message.Chat.ID, text)
-					msg.ParseMode = tgbotapi.ModeMarkdown
+					msg.ParseMode = tgbotapi.ModeHTML
+					msg.ReplyMarkup = keyboard
 					bot.Send(msg)
 
 				case "set_model":
 					args := update.Message.CommandArguments()
 					argsArr := strings.Split(args, " ")
-					msg := tgbotapi.NewMessage(update.Message.Chat.ID, conf.Model.ModelName)
+					msg := tgbotapi.NewMessage(update.Message.Chat.ID, history.GetModel(conf))
 					msg.ParseMode = tgbotapi.ModeMarkdown
 					switch {
 					case args == "default":
-						conf.Model.ModelName = conf.Model.ModelNameDefault
-						msg.Text = lang.Translate("commands.setModel", conf.Lang) + " `" + conf.Model.ModelName + "`"
+						history.SetModel("")
+						msg.Text = lang.Translate("commands.setModel", conf.Lang) + " `" + history.GetModel(conf) + "`"
 					case args == "":
 						msg.Text = lang.Translate("commands.noArgsModel", conf.Lang)
 					case len(argsArr) > 1:
 						msg.Text = lang.Translate("commands.noSpaceModel", conf.Lang)
+					case catalog.Loaded() && !catalog.Has(argsArr[0]):
+						msg = unknownModelMessage(update.Message.Chat.ID, argsArr[0], conf, catalog)
 					default:
-						conf.Model.ModelName = argsArr[0]
-						msg.Text = lang.Translate("commands.setModel", conf.Lang) + " `" + conf.Model.ModelName + "`"
+						history.SetModel(argsArr[0])
+						msg.Text = lang.Translate("commands.setModel", conf.Lang) + " `" + history.GetModel(conf) + "`"
 					}
 					bot.Send(msg)
 
 				case "stats":
-					userStats.CheckHistory(conf.MaxHistorySize, conf.MaxHistoryTime)
+					// Расходы — личные, а число сообщений — в истории, где бот отвечает в этом чате
+					history.CheckHistory(conf.MaxHistorySize, conf.MaxHistoryTime)
 					counted := strconv.FormatFloat(userStats.GetCurrentCost(conf.BudgetPeriod), 'f', 6, 64)
 					daily := strconv.FormatFloat(userStats.GetCurrentCost("daily"), 'f', 6, 64)
 					monthly := strconv.FormatFloat(userStats.GetCurrentCost("monthly"), 'f', 6, 64)
 					total := strconv.FormatFloat(userStats.GetCurrentCost("total"), 'f', 6, 64)
-					msgs := strconv.Itoa(len(userStats.GetMessages()))
+					msgs := strconv.Itoa(len(history.GetMessages()))
 					text := fmt.Sprintf(lang.Translate("commands.stats", conf.Lang), counted, daily, monthly, total, msgs)
 					msg := tgbotapi.NewMessage(update.Message.Chat.ID, text)
 					msg.ParseMode = "HTML"
 					bot.Send(msg)
+
+				case "persona_set":
+					name, _, _ := strings.Cut(strings.TrimSpace(update.Message.CommandArguments()), "\n")
+					base, _ := personas.Get(conf, strings.TrimSpace(name))
+					persona, err := parsePersona(update.Message.CommandArguments(), base)
+					text := fmt.Sprintf(lang.Translate("personas.saved", conf.Lang), html.EscapeString(persona.Name))
+					if err != nil {
+						text = lang.Translate("personas.usage", conf.Lang)
+					} else if err := personas.Save(persona); err != nil {
+						log.Printf("Error saving persona: %v", err)
+						text = lang.Translate("errorText", conf.Lang)
+					}
+					msg := tgbotapi.NewMessage(update.Message.Chat.ID, text)
+					msg.ParseMode = tgbotapi.ModeHTML
+					bot.Send(msg)
+
+				case "persona_del":
+					name := strings.TrimSpace(update.Message.CommandArguments())
+					text := fmt.Sprintf(lang.Translate("personas.deleted", conf.Lang), html.EscapeString(name))
+					if deleted, err := personas.Delete(name); err != nil {
+						log.Printf("Error deleting persona: %v", err)
+						text = lang.Translate("errorText", conf.Lang)
+					} else if !deleted {
+						text = lang.Translate("personas.notDeletable", conf.Lang)
+					}
+					msg := tgbotapi.NewMessage(update.Message.Chat.ID, text)
+					msg.ParseMode = tgbotapi.ModeHTML
+					bot.Send(msg)
 				}
 			}
+		} else if update.Message.MediaGroupID != "" && update.Message.Photo != nil && !edited {
+			// Альбом собирается целиком и обрабатывается одним запросом
+			quote := update.Quote
+			albums.Add(update.Message, func(parts []*tgbotapi.Message) {
+				message, photos := albumMessage(parts)
+				request, history, ok := newChatRequest(bot, message, quote, userStats, userManager, conf)
+				if !ok {
+					return
+				}
+				request.Photos = photos
+				processMessage(bot, client, request, conf, userStats, history, catalog)
+			})
 		} else {
-			// Обычные сообщения
-			go func() {
-				if userStats.HaveAccess(conf) {
-					responseID := api.HandleChatGPTStreamResponse(bot, client, update.Message, conf, userStats)
-					if conf.Model.Type == "openrouter" {
-						userStats.GetUsageFromApi(responseID, conf)
-					}
-				} else {
+			// Обычные сообщения; в группе — только обращённые к боту
+			request, history, ok := newChatRequest(bot, update.Message, update.Quote, userStats, userManager, conf)
+			if !ok {
+				continue
+			}
+			if edited {
+				// Перегенерировать можно только последний ход; ответ заменяется на месте
+				if !history.IsLastTurn(update.Message.Chat.ID, update.Message.MessageID) {
+					continue
+				}
+				// Доступ проверяется до того, как ход убран из истории, иначе он пропал бы без нового ответа
+				if !userStats.HaveAccess(conf) {
 					bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, lang.Translate("budget_out", conf.Lang)))
+					continue
 				}
-			}()
+				turn, answerIDs, ok := history.TakeLastTurn(update.Message.Chat.ID, update.Message.MessageID)
+				if !ok {
+					continue
+				}
+				request.Photos = turn.Images
+				request.AnswerIDs = answerIDs
+			}
+			go processMessage(bot, client, request, conf, userStats, history, catalog)
 		}
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// botUpdate — обновление Telegram с полями, которых нет в tgbotapi
+type botUpdate struct {
+	tgbotapi.Update
+	Quote string // Фрагмент сообщения, процитированный в ответе на него
+}
+
+// quotedMessage разбирает только цитату из сообщения
+type quotedMessage struct {
+	Quote *struct {
+		Text string `json:"text"`
+	} `json:"quote"`
+}
+
+// getUpdatesChan повторяет tgbotapi.GetUpdatesChan, дополнительно разбирая цитаты
+func getUpdatesChan(bot *tgbotapi.BotAPI, config tgbotapi.UpdateConfig) <-chan botUpdate {
+	ch := make(chan botUpdate, bot.Buffer)
+
+	go func() {
+		for {
+			updates, err := getUpdates(bot, config)
+			if err != nil {
+				log.Println(err)
+				log.Println("Failed to get updates, retrying in 3 seconds...")
+				time.Sleep(time.Second * 3)
+				continue
+			}
+
+			for _, update := range updates {
+				if update.UpdateID >= config.Offset {
+					config.Offset = update.UpdateID + 1
+					ch <- update
+				}
+			}
+		}
+	}()
+
+	return ch
+}
+
+func getUpdates(bot *tgbotapi.BotAPI, config tgbotapi.UpdateConfig) ([]botUpdate, error) {
+	resp, err := bot.Request(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var updates []tgbotapi.Update
+	if err := json.Unmarshal(resp.Result, &updates); err != nil {
+		return nil, err
+	}
+	var quotes []struct {
+		Message       *quotedMessage `json:"message"`
+		EditedMessage *quotedMessage `json:"edited_message"`
+	}
+	if err := json.Unmarshal(resp.Result, &quotes); err != nil {
+		return nil, err
+	}
+
+	result := make([]botUpdate, len(updates))
+	for i, update := range updates {
+		result[i].Update = update
+		message := quotes[i].Message
+		if message == nil {
+			message = quotes[i].EditedMessage
+		}
+		if message != nil && message.Quote != nil {
+			result[i].Quote = message.Quote.Text
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,224 @@
+package user
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultConversation is the ID of the conversation every user starts with
+const DefaultConversation = ""
+
+var (
+	ErrConversationExists   = errors.New("conversation already exists")
+	ErrConversationNotFound = errors.New("conversation not found")
+	ErrDefaultConversation  = errors.New("default conversation cannot be changed")
+)
+
+// Conversation is a named chat of a user with its own history, prompt and model.
+type Conversation struct {
+	ID           string    `json:"-"`
+	Name         string    `json:"name"`
+	SystemPrompt string    `json:"system_prompt,omitempty"`
+	Model        string    `json:"model,omitempty"`
+	Persona      string    `json:"persona,omitempty"`
+	PersonaModel string    `json:"persona_model,omitempty"` // Модель персоны поверх выбранной пользователем
+	Temperature  *float64  `json:"temperature,omitempty"`
+	MaxTokens    int       `json:"max_tokens,omitempty"`
+	Created      time.Time `json:"created"`
+
+	// Параметры сэмплирования, заданные командой /params
+	Params map[string]float64 `json:"params,omitempty"`
+}
+
+// ActiveModel returns the model of the persona or, without it, the model chosen
+// for the conversation; empty means the default model.
+func (c Conversation) ActiveModel() string {
+	if c.PersonaModel != "" {
+		return c.PersonaModel
+	}
+	return c.Model
+}
+
+// conversation returns the current conversation; the caller must hold UsageMu
+func (ut *UsageTracker) conversation() *Conversation {
+	if ut.Usage.Conversations == nil {
+		ut.Usage.Conversations = make(map[string]*Conversation)
+	}
+	if _, ok := ut.Usage.Conversations[ut.Usage.CurrentConversation]; !ok {
+		ut.Usage.CurrentConversation = DefaultConversation
+	}
+	conv, ok := ut.Usage.Conversations[DefaultConversation]
+	if !ok {
+		// Модель, выбранная до появления разговоров, переходит в разговор по умолчанию
+		conv = &Conversation{Name: "default", Model: ut.Usage.Model, Created: time.Now()}
+		ut.Usage.Conversations[DefaultConversation] = conv
+		ut.Usage.Model = ""
+	}
+	current := ut.Usage.Conversations[ut.Usage.CurrentConversation]
+	current.ID = ut.Usage.CurrentConversation
+	return current
+}
+
+// CurrentConversation returns a copy of the current conversation.
+func (ut *UsageTracker) CurrentConversation() Conversation {
+	ut.UsageMu.Lock()
+	defer ut.UsageMu.Unlock()
+	return *ut.conversation()
+}
+
+// Conversations returns all conversations of the user, the default one first.
+func (ut *UsageTracker) Conversations() []Conversation {
+	ut.UsageMu.Lock()
+	defer ut.UsageMu.Unlock()
+	ut.conversation()
+
+	list := make([]Conversation, 0, len(ut.Usage.Conversations))
+	for id, conv := range ut.Usage.Conversations {
+		c := *conv
+		c.ID = id
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].ID == DefaultConversation || list[j].ID == DefaultConversation {
+			return list[i].ID == DefaultConversation
+		}
+		return list[i].Created.Before(list[j].Created)
+	})
+	return list
+}
+
+// FindConversation looks a conversation up by ID, name (case-insensitive) or
+// its 1-based position in the Conversations list.
+func (ut *UsageTracker) FindConversation(query string) (Conversation, bool) {
+	query = strings.TrimSpace(query)
+	list := ut.Conversations()
+	if n, err := strconv.Atoi(query); err == nil && n >= 1 && n <= len(list) {
+		return list[n-1], true
+	}
+	for _, conv := range list {
+		if conv.ID == query || strings.EqualFold(conv.Name, query) {
+			return conv, true
+		}
+	}
+	return Conversation{}, false
+}
+
+// NewConversation creates a conversation that inherits the current model and switches to it.
+func (ut *UsageTracker) NewConversation(name string) (Conversation, error) {
+	ut.UsageMu.Lock()
+	current := ut.conversation()
+	if name == "" {
+		name = "chat " + strconv.Itoa(len(ut.Usage.Conversations))
+	}
+	if ut.conversationByName(name) != nil {
+		ut.UsageMu.Unlock()
+		return Conversation{}, ErrConversationExists
+	}
+	conv := &Conversation{
+		ID:      strconv.FormatInt(time.Now().UnixNano(), 36),
+		Name:    name,
+		Model:   current.Model,
+		Created: time.Now(),
+	}
+	ut.Usage.Conversations[conv.ID] = conv
+	ut.UsageMu.Unlock()
+
+	if err := ut.SwitchConversation(conv.ID); err != nil {
+		return Conversation{}, err
+	}
+	return *conv, nil
+}
+
+// SwitchConversation makes the conversation current and loads its history.
+func (ut *UsageTracker) SwitchConversation(id string) error {
+	ut.History.mu.Lock()
+	defer ut.History.mu.Unlock()
+
+	ut.UsageMu.Lock()
+	ut.conversation()
+	if _, ok := ut.Usage.Conversations[id]; !ok {
+		ut.UsageMu.Unlock()
+		return ErrConversationNotFound
+	}
+	ut.Usage.CurrentConversation = id
+	ut.UsageMu.Unlock()
+
+	if err := ut.saveUsage(); err != nil {
+		log.Printf("Failed to save conversation for user %s: %v", ut.UserID, err)
+	}
+	ut.LastMessageTime = time.Time{}
+	ut.loadHistoryLocked()
+	return nil
+}
+
+// RenameConversation renames the current conversation.
+func (ut *UsageTracker) RenameConversation(name string) error {
+	ut.UsageMu.Lock()
+	conv := ut.conversation()
+	switch {
+	case conv.ID == DefaultConversation:
+		ut.UsageMu.Unlock()
+		return ErrDefaultConversation
+	case ut.conversationByName(name) != nil:
+		ut.UsageMu.Unlock()
+		return ErrConversationExists
+	}
+	conv.Name = name
+	ut.UsageMu.Unlock()
+
+	return ut.saveUsage()
+}
+
+// DeleteConversation removes a conversation and its history. Deleting the
+// current conversation switches to the default one.
+func (ut *UsageTracker) DeleteConversation(id string) error {
+	if id == DefaultConversation {
+		return ErrDefaultConversation
+	}
+
+	ut.UsageMu.Lock()
+	current := ut.conversation().ID
+	if _, ok := ut.Usage.Conversations[id]; !ok {
+		ut.UsageMu.Unlock()
+		return ErrConversationNotFound
+	}
+	delete(ut.Usage.Conversations, id)
+	ut.UsageMu.Unlock()
+
+	if err := ut.store.Save(ut.historyKey(id), nil); err != nil {
+		log.Printf("Error removing history for user %s: %v", ut.UserID, err)
+	}
+	if current == id {
+		return ut.SwitchConversation(DefaultConversation)
+	}
+	return ut.saveUsage()
+}
+
+// conversationByName finds a conversation by name; the caller must hold UsageMu
+func (ut *UsageTracker) conversationByName(name string) *Conversation {
+	for _, conv := range ut.Usage.Conversations {
+		if strings.EqualFold(conv.Name, name) {
+			return conv
+		}
+	}
+	return nil
+}
+
+// historyKey returns the history store key of a conversation
+func (ut *UsageTracker) historyKey(id string) string {
+	if id == DefaultConversation {
+		return ut.UserID
+	}
+	return ut.UserID + "." + id
+}
+
+// currentHistoryKey returns the history store key of the current conversation
+func (ut *UsageTracker) currentHistoryKey() string {
+	ut.UsageMu.Lock()
+	defer ut.UsageMu.Unlock()
+	return ut.historyKey(ut.conversation().ID)
+}
@@ -0,0 +1,182 @@
+package user
+
+import (
+	"errors"
+	"testing"
+)
+
+// newConversationTracker returns a tracker whose history is kept in a file store,
+// so that switching conversations loads the history from disk
+func newConversationTracker(t *testing.T) *UsageTracker {
+	t.Helper()
+	ut := newTestTracker(t)
+	store, err := NewFileHistoryStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ut.store = store
+	return ut
+}
+
+func TestConversationHistories(t *testing.T) {
+	ut := newConversationTracker(t)
+	ut.SetModel("model/a")
+	ut.AddMessage("user", "default question", 1)
+
+	work, err := ut.NewConversation("Work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ut.CurrentConversation(); got.ID != work.ID || got.Model != "model/a" {
+		t.Fatalf("current conversation %+v, want %q inheriting model/a", got, work.ID)
+	}
+	assertContents(t, ut.GetMessages(), []string{})
+	ut.AddMessage("user", "work question", 1)
+
+	if _, err := ut.NewConversation("work"); !errors.Is(err, ErrConversationExists) {
+		t.Errorf("NewConversation with a taken name: %v, want %v", err, ErrConversationExists)
+	}
+
+	if err := ut.SwitchConversation(DefaultConversation); err != nil {
+		t.Fatal(err)
+	}
+	assertContents(t, ut.GetMessages(), []string{"default question"})
+
+	if err := ut.SwitchConversation(work.ID); err != nil {
+		t.Fatal(err)
+	}
+	assertContents(t, ut.GetMessages(), []string{"work question"})
+
+	if err := ut.SwitchConversation("missing"); !errors.Is(err, ErrConversationNotFound) {
+		t.Errorf("SwitchConversation(missing): %v, want %v", err, ErrConversationNotFound)
+	}
+
+	// Разговоры и текущий разговор сохраняются в файле пользователя
+	reloaded := NewUsageTracker(ut.UserID, ut.UserName, ut.LogsDir, nil)
+	reloaded.store = ut.store
+	reloaded.loadHistory()
+	if got := reloaded.CurrentConversation(); got.ID != work.ID || got.Name != "Work" {
+		t.Errorf("reloaded current conversation %+v, want %q", got, work.ID)
+	}
+	assertContents(t, reloaded.GetMessages(), []string{"work question"})
+}
+
+func TestFindConversation(t *testing.T) {
+	ut := newConversationTracker(t)
+	first, err := ut.NewConversation("First")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := ut.NewConversation("Second")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query  string
+		wantID string
+		found  bool
+	}{
+		{"1", DefaultConversation, true},
+		{"2", first.ID, true},
+		{" 3 ", second.ID, true},
+		{"4", "", false},
+		{"0", "", false},
+		{"second", second.ID, true},
+		{first.ID, first.ID, true},
+		{"third", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			got, found := ut.FindConversation(tt.query)
+			if found != tt.found || got.ID != tt.wantID {
+				t.Errorf("FindConversation(%q) = %q, %v; want %q, %v", tt.query, got.ID, found, tt.wantID, tt.found)
+			}
+		})
+	}
+}
+
+func TestRenameConversation(t *testing.T) {
+	tests := []struct {
+		name    string
+		current string // Имя текущего разговора; пустое — разговор по умолчанию
+		newName string
+		want    error
+	}{
+		{"rename", "Work", "Job", nil},
+		{"same name other case", "Work", "WORK", ErrConversationExists},
+		{"taken name", "Work", "Other", ErrConversationExists},
+		{"default conversation", "", "Main", ErrDefaultConversation},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ut := newConversationTracker(t)
+			if _, err := ut.NewConversation("Other"); err != nil {
+				t.Fatal(err)
+			}
+			ut.SwitchConversation(DefaultConversation)
+			if tt.current != "" {
+				if _, err := ut.NewConversation(tt.current); err != nil {
+					t.Fatal(err)
+				}
+			}
+			before := ut.CurrentConversation().Name
+
+			err := ut.RenameConversation(tt.newName)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("RenameConversation(%q): %v, want %v", tt.newName, err, tt.want)
+			}
+			want := before
+			if tt.want == nil {
+				want = tt.newName
+			}
+			if got := ut.CurrentConversation().Name; got != want {
+				t.Errorf("conversation name %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestDeleteConversation(t *testing.T) {
+	ut := newConversationTracker(t)
+	ut.AddMessage("user", "default question", 1)
+	other, _ := ut.NewConversation("Other")
+	ut.AddMessage("user", "other question", 1)
+	work, _ := ut.NewConversation("Work")
+	ut.AddMessage("user", "work question", 1)
+
+	if err := ut.DeleteConversation(DefaultConversation); !errors.Is(err, ErrDefaultConversation) {
+		t.Errorf("DeleteConversation(default): %v, want %v", err, ErrDefaultConversation)
+	}
+	if err := ut.DeleteConversation("missing"); !errors.Is(err, ErrConversationNotFound) {
+		t.Errorf("DeleteConversation(missing): %v, want %v", err, ErrConversationNotFound)
+	}
+
+	// Удаление другого разговора не меняет текущий
+	if err := ut.DeleteConversation(other.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got := ut.CurrentConversation().ID; got != work.ID {
+		t.Errorf("current conversation %q after deleting another one, want %q", got, work.ID)
+	}
+	assertContents(t, ut.GetMessages(), []string{"work question"})
+
+	// Удаление текущего переключает на разговор по умолчанию
+	if err := ut.DeleteConversation(work.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got := ut.CurrentConversation().ID; got != DefaultConversation {
+		t.Errorf("current conversation %q after deleting it, want the default one", got)
+	}
+	assertContents(t, ut.GetMessages(), []string{"default question"})
+	if got := len(ut.Conversations()); got != 1 {
+		t.Errorf("%d conversations left, want 1", got)
+	}
+
+	for _, id := range []string{other.ID, work.ID} {
+		messages, err := ut.store.Load(ut.historyKey(id))
+		if err != nil || messages != nil {
+			t.Errorf("history of deleted conversation %q: %v, %v; want it removed", id, messages, err)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package user
+
+import (
+	"log"
+	"openrouter-bot/config"
+)
+
+// GroupSettings задают, когда бот отвечает в группе и чью историю использует
+type GroupSettings struct {
+	Mention       bool `json:"mention"`        // Отвечать на упоминание @бота
+	Reply         bool `json:"reply"`          // Отвечать на ответ на сообщение бота
+	SharedHistory bool `json:"shared_history"` // Общая история чата вместо личной истории участника
+}
+
+// GetGroupSettings returns the chat settings or the defaults from config if they were never changed.
+func (ut *UsageTracker) GetGroupSettings(conf *config.Config) GroupSettings {
+	ut.UsageMu.Lock()
+	defer ut.UsageMu.Unlock()
+
+	if ut.Usage.Group != nil {
+		return *ut.Usage.Group
+	}
+	return defaultGroupSettings(conf)
+}
+
+// defaultGroupSettings returns the group settings from config
+func defaultGroupSettings(conf *config.Config) GroupSettings {
+	return GroupSettings{
+		Mention:       conf.GroupMention,
+		Reply:         conf.GroupReply,
+		SharedHistory: conf.GroupSharedHistory,
+	}
+}
+
+// SetGroupSettings stores the chat settings.
+func (ut *UsageTracker) SetGroupSettings(settings GroupSettings) {
+	ut.UsageMu.Lock()
+	ut.Usage.Group = &settings
+	ut.UsageMu.Unlock()
+
+	if err := ut.saveUsage(); err != nil {
+		log.Printf("Failed to save group settings for chat %s: %v", ut.UserID, err)
+	}
+}
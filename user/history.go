@@ -1,11 +1,29 @@
 package user
 
-import "time"
+import (
+	"log"
+	"slices"
+	"time"
 
-func (ut *UsageTracker) AddMessage(role, content string) {
+	"github.com/sashabaranov/go-openai"
+)
+
+// AddMessage appends a message to the history; chatID and messageIDs link it to
+// the Telegram messages it was sent in.
+func (ut *UsageTracker) AddMessage(role, content string, chatID int64, messageIDs ...int) {
+	ut.AddImageMessage(role, content, nil, chatID, messageIDs...)
+}
+
+// AddImageMessage appends a message with images. Images are kept as Telegram
+// file IDs and resolved again each time the history is sent to the model.
+func (ut *UsageTracker) AddImageMessage(role, content string, images []string, chatID int64, messageIDs ...int) {
 	ut.History.mu.Lock()
 	defer ut.History.mu.Unlock()
-	ut.History.messages = append(ut.History.messages, Message{Role: role, Content: content})
+	msg := Message{Role: role, Content: content, Time: time.Now(), Images: images, ChatID: chatID, MessageIDs: messageIDs}
+	ut.History.messages = append(ut.History.messages, msg)
+	if err := ut.store.Append(ut.currentHistoryKey(), msg); err != nil {
+		log.Printf("Error saving history for user %s: %v", ut.UserID, err)
+	}
 }
 
 func (ut *UsageTracker) GetMessages() []Message {
@@ -18,22 +36,220 @@ func (ut *UsageTracker) ClearHistory() {
 	ut.History.mu.Lock()
 	defer ut.History.mu.Unlock()
 	ut.History.messages = []Message{}
+	if err := ut.store.Save(ut.currentHistoryKey(), nil); err != nil {
+		log.Printf("Error clearing history for user %s: %v", ut.UserID, err)
+	}
 }
 
 func (ut *UsageTracker) CheckHistory(maxMessages int, maxTime int) {
 	ut.History.mu.Lock()
 	defer ut.History.mu.Unlock()
+	size := len(ut.History.messages)
+	ut.expireHistory(maxTime)
+
+	if len(ut.History.messages) > maxMessages {
+		// Удаляем первые сообщения, чтобы оставить только последние maxMessages
+		ut.History.messages = ut.History.messages[len(ut.History.messages)-maxMessages:]
+	}
+
+	if len(ut.History.messages) != size {
+		ut.saveHistory()
+	}
+}
+
+// ExpireHistory clears the history if the user has been idle longer than maxTime minutes.
+func (ut *UsageTracker) ExpireHistory(maxTime int) {
+	ut.History.mu.Lock()
+	defer ut.History.mu.Unlock()
+	size := len(ut.History.messages)
+	ut.expireHistory(maxTime)
+	if len(ut.History.messages) != size {
+		ut.saveHistory()
+	}
+}
+
+func (ut *UsageTracker) expireHistory(maxTime int) {
 	//Удаляем старые сообщения
 	if ut.LastMessageTime.IsZero() {
 		ut.LastMessageTime = time.Now()
 	}
+	// Именованные разговоры хранятся до удаления, по времени очищается только разговор по умолчанию
+	if ut.CurrentConversation().ID != DefaultConversation {
+		return
+	}
 	if ut.LastMessageTime.Before(time.Now().Add(-time.Duration(maxTime) * time.Minute)) {
 		// Remove messages older than the maximum time limit
 		ut.History.messages = make([]Message, 0)
 	}
+}
 
-	if len(ut.History.messages) > maxMessages {
-		// Удаляем первые сообщения, чтобы оставить только последние maxMessages
-		ut.History.messages = ut.History.messages[len(ut.History.messages)-maxMessages:]
+// HistoryOverflow returns how many of the oldest messages have to be removed so
+// that at most maxMessages remain and they fit into tokenBudget tokens
+// (tokenBudget < 0 disables the token limit). If anything is removed, the
+// remaining history starts with a user message.
+func (ut *UsageTracker) HistoryOverflow(maxMessages, tokenBudget int) int {
+	ut.History.mu.Lock()
+	defer ut.History.mu.Unlock()
+
+	messages := ut.History.messages
+	total := 0
+	for _, msg := range messages {
+		total += EstimateMessageTokens(msg.Content)
+	}
+
+	n := 0
+	for n < len(messages) {
+		overflow := len(messages)-n > maxMessages || (tokenBudget >= 0 && total > tokenBudget)
+		if !overflow && (n == 0 || messages[n].Role == openai.ChatMessageRoleUser) {
+			break
+		}
+		total -= EstimateMessageTokens(messages[n].Content)
+		n++
+	}
+	return n
+}
+
+// DropOldest removes the n oldest messages from the history.
+func (ut *UsageTracker) DropOldest(n int) {
+	ut.History.mu.Lock()
+	defer ut.History.mu.Unlock()
+	n = min(n, len(ut.History.messages))
+	if n <= 0 {
+		return
+	}
+	ut.History.messages = ut.History.messages[n:]
+	log.Printf("History of user %s trimmed to %d messages", ut.UserID, len(ut.History.messages))
+	ut.saveHistory()
+}
+
+// ReplaceWithSummary replaces the n oldest messages with a summary message kept
+// at the head of the history.
+func (ut *UsageTracker) ReplaceWithSummary(n int, summary string) {
+	ut.History.mu.Lock()
+	defer ut.History.mu.Unlock()
+	n = min(n, len(ut.History.messages))
+	msg := Message{Role: openai.ChatMessageRoleSystem, Content: summary, Time: time.Now(), Summary: true}
+	ut.History.messages = append([]Message{msg}, ut.History.messages[n:]...)
+	log.Printf("History of user %s summarized, %d messages replaced", ut.UserID, n)
+	ut.saveHistory()
+}
+
+// RewindTo drops the messages that follow the history message sent as messageID
+// in chatID, so the conversation continues from that point. It reports whether
+// the message is in the history.
+func (ut *UsageTracker) RewindTo(chatID int64, messageID int) bool {
+	ut.History.mu.Lock()
+	defer ut.History.mu.Unlock()
+	i := ut.indexOf(chatID, messageID)
+	if i < 0 {
+		return false
+	}
+	if dropped := len(ut.History.messages) - i - 1; dropped > 0 {
+		// Копируем, чтобы не перезаписать срез, полученный через GetMessages
+		ut.History.messages = append([]Message(nil), ut.History.messages[:i+1]...)
+		log.Printf("History of user %s rewound, %d messages dropped", ut.UserID, dropped)
+		ut.saveHistory()
+	}
+	return true
+}
+
+// MessagesUntil returns the history up to the message sent as messageID in
+// chatID without changing it, and whether the message is in the history.
+func (ut *UsageTracker) MessagesUntil(chatID int64, messageID int) ([]Message, bool) {
+	ut.History.mu.Lock()
+	defer ut.History.mu.Unlock()
+	i := ut.indexOf(chatID, messageID)
+	if i < 0 {
+		return nil, false
+	}
+	return ut.History.messages[: i+1 : i+1], true
+}
+
+// indexOf returns the index of the last history message sent as messageID in
+// chatID, or -1; the caller must hold History.mu
+func (ut *UsageTracker) indexOf(chatID int64, messageID int) int {
+	for i := len(ut.History.messages) - 1; i >= 0; i-- {
+		msg := ut.History.messages[i]
+		if msg.ChatID == chatID && slices.Contains(msg.MessageIDs, messageID) {
+			return i
+		}
+	}
+	return -1
+}
+
+// lastTurn returns the index of the last user message if messageID in chatID is
+// that message or a part of the answer to it, or -1; the caller must hold History.mu
+func (ut *UsageTracker) lastTurn(chatID int64, messageID int) int {
+	messages := ut.History.messages
+	i := len(messages) - 1
+	for i >= 0 && messages[i].Role != openai.ChatMessageRoleUser {
+		i--
+	}
+	if i < 0 {
+		return -1
+	}
+	for _, msg := range messages[i:] {
+		if msg.ChatID == chatID && slices.Contains(msg.MessageIDs, messageID) {
+			return i
+		}
+	}
+	return -1
+}
+
+// IsLastTurn reports whether messageID in chatID is the last user message or a
+// part of the answer to it.
+func (ut *UsageTracker) IsLastTurn(chatID int64, messageID int) bool {
+	ut.History.mu.Lock()
+	defer ut.History.mu.Unlock()
+	return ut.lastTurn(chatID, messageID) >= 0
+}
+
+// TakeLastTurn removes the last user message together with the answer to it if
+// messageID in chatID is one of them. It returns the user message, the IDs of
+// the answer messages and whether the turn was found.
+func (ut *UsageTracker) TakeLastTurn(chatID int64, messageID int) (Message, []int, bool) {
+	ut.History.mu.Lock()
+	defer ut.History.mu.Unlock()
+	i := ut.lastTurn(chatID, messageID)
+	if i < 0 {
+		return Message{}, nil, false
+	}
+
+	messages := ut.History.messages
+	var answerIDs []int
+	for _, msg := range messages[i+1:] {
+		answerIDs = append(answerIDs, msg.MessageIDs...)
+	}
+	ut.History.messages = append([]Message(nil), messages[:i]...)
+	ut.saveHistory()
+	return messages[i], answerIDs, true
+}
+
+// saveHistory rewrites the stored history; the caller must hold History.mu
+func (ut *UsageTracker) saveHistory() {
+	if err := ut.store.Save(ut.currentHistoryKey(), ut.History.messages); err != nil {
+		log.Printf("Error saving history for user %s: %v", ut.UserID, err)
+	}
+}
+
+// loadHistory restores the history saved before restart
+func (ut *UsageTracker) loadHistory() {
+	ut.History.mu.Lock()
+	defer ut.History.mu.Unlock()
+	ut.loadHistoryLocked()
+}
+
+// loadHistoryLocked loads the history of the current conversation; the caller must hold History.mu
+func (ut *UsageTracker) loadHistoryLocked() {
+	messages, err := ut.store.Load(ut.currentHistoryKey())
+	if err != nil {
+		log.Printf("Error loading history for user %s: %v", ut.UserID, err)
+		messages = nil
+	}
+
+	ut.History.messages = append(make([]Message, 0, len(messages)), messages...)
+	// Время последнего сообщения нужно, чтобы MAX_HISTORY_TIME учитывал время простоя
+	if len(messages) > 0 && !messages[len(messages)-1].Time.IsZero() {
+		ut.LastMessageTime = messages[len(messages)-1].Time
 	}
 }
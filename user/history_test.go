@@ -0,0 +1,198 @@
+package user
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// newTestTracker returns a tracker with usage in a temporary directory and history only in memory
+func newTestTracker(t *testing.T, messages ...Message) *UsageTracker {
+	t.Helper()
+	ut := NewUsageTracker("1", "test", t.TempDir(), nil)
+	ut.History.messages = append([]Message(nil), messages...)
+	return ut
+}
+
+func TestHistoryOverflow(t *testing.T) {
+	// Каждое сообщение — 1 токен текста и служебные токены
+	user := Message{Role: openai.ChatMessageRoleUser, Content: "aaaa"}
+	assistant := Message{Role: openai.ChatMessageRoleAssistant, Content: "aaaa"}
+	summary := Message{Role: openai.ChatMessageRoleSystem, Content: "aaaa", Summary: true}
+	size := EstimateMessageTokens("aaaa")
+	turns := []Message{user, assistant, user, assistant}
+
+	tests := []struct {
+		name        string
+		messages    []Message
+		maxMessages int
+		budget      int
+		want        int
+	}{
+		{"empty", nil, 10, -1, 0},
+		{"fits", turns, 10, -1, 0},
+		{"fits exactly", turns, 4, 4 * size, 0},
+		{"too many messages", turns, 2, -1, 2},
+		{"keeps user message first", turns, 3, -1, 2},
+		{"token budget", turns, 10, 2 * size, 2},
+		{"token budget between turns", turns, 10, 2*size - 1, 4},
+		{"zero budget", turns, 10, 0, 4},
+		{"no messages allowed", turns, 0, -1, 4},
+		{"summary dropped first", []Message{summary, user, assistant}, 2, -1, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ut := newTestTracker(t, tt.messages...)
+			if got := ut.HistoryOverflow(tt.maxMessages, tt.budget); got != tt.want {
+				t.Errorf("HistoryOverflow(%d, %d) = %d, want %d", tt.maxMessages, tt.budget, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDropOldest(t *testing.T) {
+	messages := []Message{
+		{Role: openai.ChatMessageRoleUser, Content: "1"},
+		{Role: openai.ChatMessageRoleAssistant, Content: "2"},
+		{Role: openai.ChatMessageRoleUser, Content: "3"},
+	}
+	tests := []struct {
+		name string
+		n    int
+		want []string
+	}{
+		{"none", 0, []string{"1", "2", "3"}},
+		{"some", 2, []string{"3"}},
+		{"more than history", 5, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ut := newTestTracker(t, messages...)
+			ut.DropOldest(tt.n)
+			assertContents(t, ut.GetMessages(), tt.want)
+		})
+	}
+}
+
+// assertContents compares the texts of the history messages
+func assertContents(t *testing.T, messages []Message, want []string) {
+	t.Helper()
+	got := make([]string, 0, len(messages))
+	for _, msg := range messages {
+		got = append(got, msg.Content)
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("history %q, want %q", got, want)
+	}
+}
+
+// turnHistory — два хода в чате 10: вопросы в сообщениях 1 и 4, ответы в 2, 3 и 5
+func turnHistory() []Message {
+	return []Message{
+		{Role: openai.ChatMessageRoleUser, Content: "q1", ChatID: 10, MessageIDs: []int{1}},
+		{Role: openai.ChatMessageRoleAssistant, Content: "a1", ChatID: 10, MessageIDs: []int{2, 3}},
+		{Role: openai.ChatMessageRoleUser, Content: "q2", ChatID: 10, MessageIDs: []int{4}},
+		{Role: openai.ChatMessageRoleAssistant, Content: "a2", ChatID: 10, MessageIDs: []int{5}},
+	}
+}
+
+func TestTakeLastTurn(t *testing.T) {
+	tests := []struct {
+		name        string
+		messages    []Message
+		chatID      int64
+		messageID   int
+		found       bool
+		wantTurn    string
+		wantAnswers []int
+		wantHistory []string
+	}{
+		{"last question", turnHistory(), 10, 4, true, "q2", []int{5}, []string{"q1", "a1"}},
+		{"last answer", turnHistory(), 10, 5, true, "q2", []int{5}, []string{"q1", "a1"}},
+		{"earlier answer", turnHistory(), 10, 2, false, "", nil, []string{"q1", "a1", "q2", "a2"}},
+		{"other chat", turnHistory(), 11, 4, false, "", nil, []string{"q1", "a1", "q2", "a2"}},
+		{"unknown message", turnHistory(), 10, 99, false, "", nil, []string{"q1", "a1", "q2", "a2"}},
+		{"question without answer", turnHistory()[:3], 10, 4, true, "q2", nil, []string{"q1", "a1"}},
+		{"empty history", nil, 10, 4, false, "", nil, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ut := newTestTracker(t, tt.messages...)
+			turn, answers, found := ut.TakeLastTurn(tt.chatID, tt.messageID)
+			if found != tt.found || turn.Content != tt.wantTurn || !slices.Equal(answers, tt.wantAnswers) {
+				t.Errorf("TakeLastTurn(%d, %d) = %q, %v, %v; want %q, %v, %v",
+					tt.chatID, tt.messageID, turn.Content, answers, found, tt.wantTurn, tt.wantAnswers, tt.found)
+			}
+			assertContents(t, ut.GetMessages(), tt.wantHistory)
+		})
+	}
+}
+
+func TestIsLastTurn(t *testing.T) {
+	tests := []struct {
+		name      string
+		chatID    int64
+		messageID int
+		want      bool
+	}{
+		{"last question", 10, 4, true},
+		{"last answer", 10, 5, true},
+		{"earlier question", 10, 1, false},
+		{"earlier answer part", 10, 3, false},
+		{"other chat", 11, 5, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ut := newTestTracker(t, turnHistory()...)
+			if got := ut.IsLastTurn(tt.chatID, tt.messageID); got != tt.want {
+				t.Errorf("IsLastTurn(%d, %d) = %v, want %v", tt.chatID, tt.messageID, got, tt.want)
+			}
+			assertContents(t, ut.GetMessages(), []string{"q1", "a1", "q2", "a2"})
+		})
+	}
+}
+
+func TestRewindTo(t *testing.T) {
+	tests := []struct {
+		name        string
+		chatID      int64
+		messageID   int
+		found       bool
+		wantHistory []string
+	}{
+		{"earlier answer", 10, 2, true, []string{"q1", "a1"}},
+		{"second part of earlier answer", 10, 3, true, []string{"q1", "a1"}},
+		{"earlier question", 10, 1, true, []string{"q1"}},
+		{"last answer", 10, 5, true, []string{"q1", "a1", "q2", "a2"}},
+		{"other chat", 11, 2, false, []string{"q1", "a1", "q2", "a2"}},
+		{"unknown message", 10, 99, false, []string{"q1", "a1", "q2", "a2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ut := newTestTracker(t, turnHistory()...)
+
+			// MessagesUntil показывает ту же историю, не меняя её
+			until, found := ut.MessagesUntil(tt.chatID, tt.messageID)
+			if found != tt.found {
+				t.Errorf("MessagesUntil(%d, %d) found = %v, want %v", tt.chatID, tt.messageID, found, tt.found)
+			}
+			if found {
+				assertContents(t, until, tt.wantHistory)
+				// Добавление к результату не затирает следующие сообщения истории
+				_ = append(until, Message{Content: "appended"})
+			}
+			assertContents(t, ut.GetMessages(), []string{"q1", "a1", "q2", "a2"})
+
+			before := ut.GetMessages()
+			if got := ut.RewindTo(tt.chatID, tt.messageID); got != tt.found {
+				t.Errorf("RewindTo(%d, %d) = %v, want %v", tt.chatID, tt.messageID, got, tt.found)
+			}
+			assertContents(t, ut.GetMessages(), tt.wantHistory)
+
+			// Новые сообщения не перезаписывают срез, полученный до отката
+			ut.AddMessage(openai.ChatMessageRoleUser, "new", tt.chatID)
+			assertContents(t, before, []string{"q1", "a1", "q2", "a2"})
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package user
+
+import (
+	"context"
+	"sync"
+)
+
+// Этапы обработки запроса, о которых сообщает /stop
+const (
+	StageTranscription = "transcription"
+	StageDocument      = "document"
+	StageGeneration    = "generation"
+	StageUsage         = "usage"
+)
+
+// Request is an in-flight request of a user that can be cancelled with /stop.
+type Request struct {
+	Ctx    context.Context
+	cancel context.CancelFunc
+	owner  *UsageTracker
+	id     int
+	mu     sync.Mutex
+	stage  string
+}
+
+// StartRequest registers a new cancellable request derived from parent.
+// The caller must call Done when the request is finished.
+func (ut *UsageTracker) StartRequest(parent context.Context) *Request {
+	ctx, cancel := context.WithCancel(parent)
+
+	ut.RequestsMu.Lock()
+	defer ut.RequestsMu.Unlock()
+	if ut.requests == nil {
+		ut.requests = make(map[int]*Request)
+	}
+	ut.requestSeq++
+	req := &Request{
+		Ctx:    ctx,
+		cancel: cancel,
+		owner:  ut,
+		id:     ut.requestSeq,
+		stage:  StageGeneration,
+	}
+	ut.requests[req.id] = req
+	return req
+}
+
+// SetStage updates the processing stage reported by /stop.
+func (r *Request) SetStage(stage string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stage = stage
+}
+
+// Stage returns the current processing stage of the request.
+func (r *Request) Stage() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stage
+}
+
+// Done releases the request context and unregisters it.
+func (r *Request) Done() {
+	r.cancel()
+	r.owner.RequestsMu.Lock()
+	defer r.owner.RequestsMu.Unlock()
+	delete(r.owner.requests, r.id)
+}
+
+// StopRequests cancels every in-flight request of the user and returns
+// the stages they were cancelled at.
+func (ut *UsageTracker) StopRequests() []string {
+	ut.RequestsMu.Lock()
+	defer ut.RequestsMu.Unlock()
+
+	stages := make([]string, 0, len(ut.requests))
+	for id, req := range ut.requests {
+		stages = append(stages, req.Stage())
+		req.cancel()
+		delete(ut.requests, id)
+	}
+	return stages
+}
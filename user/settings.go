@@ -0,0 +1,141 @@
+package user
+
+import (
+	"log"
+	"openrouter-bot/config"
+)
+
+// GetModel returns the model of the current conversation or the default model from config.
+func (ut *UsageTracker) GetModel(conf *config.Config) string {
+	ut.UsageMu.Lock()
+	defer ut.UsageMu.Unlock()
+
+	if model := ut.conversation().ActiveModel(); model != "" {
+		return model
+	}
+	return conf.Model.ModelNameDefault
+}
+
+// SetModel stores the model of the current conversation, replacing the model of
+// its persona. An empty name restores the default model.
+func (ut *UsageTracker) SetModel(model string) {
+	ut.UsageMu.Lock()
+	conv := ut.conversation()
+	conv.Model = model
+	conv.PersonaModel = ""
+	ut.UsageMu.Unlock()
+
+	if err := ut.saveUsage(); err != nil {
+		log.Printf("Failed to save model for user %s: %v", ut.UserID, err)
+	}
+}
+
+// GetSystemPrompt returns the system prompt of the current conversation or the default prompt from config.
+func (ut *UsageTracker) GetSystemPrompt(conf *config.Config) string {
+	ut.UsageMu.Lock()
+	defer ut.UsageMu.Unlock()
+
+	if prompt := ut.conversation().SystemPrompt; prompt != "" {
+		return prompt
+	}
+	return conf.SystemPrompt
+}
+
+// SetSystemPrompt stores the system prompt of the current conversation. An empty prompt restores the default one.
+func (ut *UsageTracker) SetSystemPrompt(prompt string) {
+	ut.UsageMu.Lock()
+	conv := ut.conversation()
+	conv.SystemPrompt = prompt
+	conv.Persona = ""
+	conv.PersonaModel = ""
+	ut.UsageMu.Unlock()
+
+	if err := ut.saveUsage(); err != nil {
+		log.Printf("Failed to save system prompt for user %s: %v", ut.UserID, err)
+	}
+}
+
+// GetMaxTokens returns the answer length limit of the current conversation's persona or the one from config.
+func (ut *UsageTracker) GetMaxTokens(conf *config.Config) int {
+	ut.UsageMu.Lock()
+	defer ut.UsageMu.Unlock()
+
+	if maxTokens := ut.conversation().MaxTokens; maxTokens > 0 {
+		return maxTokens
+	}
+	return conf.MaxTokens
+}
+
+// GetParameters returns the sampling parameters overridden in the current
+// conversation, including the temperature of its persona.
+func (ut *UsageTracker) GetParameters() map[string]float64 {
+	ut.UsageMu.Lock()
+	defer ut.UsageMu.Unlock()
+
+	conv := ut.conversation()
+	params := make(map[string]float64, len(conv.Params)+1)
+	for name, value := range conv.Params {
+		params[name] = value
+	}
+	if conv.Temperature != nil {
+		params["temperature"] = *conv.Temperature
+	}
+	return params
+}
+
+// SetParameter overrides the sampling parameter in the current conversation.
+// A nil value restores the one from config.
+func (ut *UsageTracker) SetParameter(name string, value *float64) {
+	ut.UsageMu.Lock()
+	conv := ut.conversation()
+	switch {
+	case name == "temperature":
+		conv.Temperature = value
+	case value == nil:
+		delete(conv.Params, name)
+	default:
+		if conv.Params == nil {
+			conv.Params = make(map[string]float64)
+		}
+		conv.Params[name] = *value
+	}
+	ut.UsageMu.Unlock()
+
+	if err := ut.saveUsage(); err != nil {
+		log.Printf("Failed to save parameters for user %s: %v", ut.UserID, err)
+	}
+}
+
+// ResetParameters removes all sampling overrides of the current conversation.
+func (ut *UsageTracker) ResetParameters() {
+	ut.UsageMu.Lock()
+	conv := ut.conversation()
+	conv.Temperature = nil
+	conv.Params = nil
+	ut.UsageMu.Unlock()
+
+	if err := ut.saveUsage(); err != nil {
+		log.Printf("Failed to save parameters for user %s: %v", ut.UserID, err)
+	}
+}
+
+// ApplyPersona copies the persona settings into the current conversation.
+// The persona model overrides the model chosen by the user without replacing it.
+// An empty persona restores the default prompt and sampling settings and the user's model.
+func (ut *UsageTracker) ApplyPersona(persona config.Persona) {
+	ut.UsageMu.Lock()
+	conv := ut.conversation()
+	conv.Persona = persona.Name
+	conv.SystemPrompt = persona.Prompt
+	conv.Temperature = persona.Temperature
+	conv.MaxTokens = persona.MaxTokens
+	if persona.Name == "" {
+		conv.Params = nil
+	}
+	conv.PersonaModel = persona.Model
+	ut.UsageMu.Unlock()
+
+	if err := ut.saveUsage(); err != nil {
+		log.Printf("Failed to save persona for user %s: %v", ut.UserID, err)
+	}
+}
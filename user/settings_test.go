@@ -0,0 +1,58 @@
+package user
+
+import (
+	"openrouter-bot/config"
+	"testing"
+)
+
+func TestPersonaModel(t *testing.T) {
+	conf := &config.Config{Model: config.ModelParameters{ModelNameDefault: "default/model"}}
+	coder := config.Persona{Name: "coder", Prompt: "You write code", Model: "persona/model"}
+	writer := config.Persona{Name: "writer", Prompt: "You write prose"}
+
+	tests := []struct {
+		name  string
+		steps func(ut *UsageTracker)
+		want  string
+	}{
+		{"default", func(ut *UsageTracker) {}, "default/model"},
+		{"chosen model", func(ut *UsageTracker) { ut.SetModel("user/model") }, "user/model"},
+		{"persona model", func(ut *UsageTracker) {
+			ut.SetModel("user/model")
+			ut.ApplyPersona(coder)
+		}, "persona/model"},
+		{"persona without model", func(ut *UsageTracker) {
+			ut.SetModel("user/model")
+			ut.ApplyPersona(writer)
+		}, "user/model"},
+		{"default persona restores chosen model", func(ut *UsageTracker) {
+			ut.SetModel("user/model")
+			ut.ApplyPersona(coder)
+			ut.ApplyPersona(config.Persona{})
+		}, "user/model"},
+		{"other persona restores chosen model", func(ut *UsageTracker) {
+			ut.SetModel("user/model")
+			ut.ApplyPersona(coder)
+			ut.ApplyPersona(writer)
+		}, "user/model"},
+		{"custom prompt restores chosen model", func(ut *UsageTracker) {
+			ut.SetModel("user/model")
+			ut.ApplyPersona(coder)
+			ut.SetSystemPrompt("Be brief")
+		}, "user/model"},
+		{"model chosen over persona", func(ut *UsageTracker) {
+			ut.ApplyPersona(coder)
+			ut.SetModel("user/model")
+			ut.ApplyPersona(config.Persona{})
+		}, "user/model"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ut := newTestTracker(t)
+			tt.steps(ut)
+			if got := ut.GetModel(conf); got != tt.want {
+				t.Errorf("GetModel() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
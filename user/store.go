@@ -0,0 +1,45 @@
+package user
+
+import (
+	"fmt"
+	"openrouter-bot/config"
+	"path/filepath"
+)
+
+// HistoryStore persists the conversation history of users between restarts.
+type HistoryStore interface {
+	// Load returns the stored history for key
+	Load(key string) ([]Message, error)
+	// Append adds messages to the end of the stored history
+	Append(key string, messages ...Message) error
+	// Save replaces the stored history; an empty slice clears it
+	Save(key string, messages []Message) error
+	Close() error
+}
+
+// NewHistoryStore creates the history store selected by HISTORY_STORE:
+// "file" (JSON lines per user in logsDir), "bolt" (embedded database) or "memory".
+func NewHistoryStore(conf *config.Config, logsDir string) (HistoryStore, error) {
+	switch conf.HistoryStore {
+	case "", "file":
+		return NewFileHistoryStore(logsDir)
+	case "bolt":
+		path := conf.HistoryDB
+		if path == "" {
+			path = filepath.Join(logsDir, "history.db")
+		}
+		return NewBoltHistoryStore(path)
+	case "memory":
+		return memoryHistoryStore{}, nil
+	default:
+		return nil, fmt.Errorf("unknown history store: %s", conf.HistoryStore)
+	}
+}
+
+// memoryHistoryStore keeps history only in memory, as before persistence was added
+type memoryHistoryStore struct{}
+
+func (memoryHistoryStore) Load(string) ([]Message, error)  { return nil, nil }
+func (memoryHistoryStore) Append(string, ...Message) error { return nil }
+func (memoryHistoryStore) Save(string, []Message) error    { return nil }
+func (memoryHistoryStore) Close() error                    { return nil }
@@ -0,0 +1,110 @@
+package user
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var historyBucket = []byte("history")
+
+// BoltHistoryStore keeps history in an embedded bbolt database,
+// one nested bucket per key with messages ordered by sequence number.
+type BoltHistoryStore struct {
+	db *bolt.DB
+}
+
+func NewBoltHistoryStore(path string) (*BoltHistoryStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("error creating history directory: %w", err)
+	}
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("error opening history database: %w", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(historyBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error creating history bucket: %w", err)
+	}
+	return &BoltHistoryStore{db: db}, nil
+}
+
+func (s *BoltHistoryStore) Load(key string) ([]Message, error) {
+	var messages []Message
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(historyBucket).Bucket([]byte(key))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(_, v []byte) error {
+			var msg Message
+			if err := json.Unmarshal(v, &msg); err != nil {
+				return fmt.Errorf("error unmarshalling history: %w", err)
+			}
+			messages = append(messages, msg)
+			return nil
+		})
+	})
+	return messages, err
+}
+
+func (s *BoltHistoryStore) Append(key string, messages ...Message) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.Bucket(historyBucket).CreateBucketIfNotExists([]byte(key))
+		if err != nil {
+			return err
+		}
+		return putMessages(bucket, messages)
+	})
+}
+
+func (s *BoltHistoryStore) Save(key string, messages []Message) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		root := tx.Bucket(historyBucket)
+		if root.Bucket([]byte(key)) != nil {
+			if err := root.DeleteBucket([]byte(key)); err != nil {
+				return err
+			}
+		}
+		if len(messages) == 0 {
+			return nil
+		}
+		bucket, err := root.CreateBucket([]byte(key))
+		if err != nil {
+			return err
+		}
+		return putMessages(bucket, messages)
+	})
+}
+
+func (s *BoltHistoryStore) Close() error {
+	return s.db.Close()
+}
+
+func putMessages(bucket *bolt.Bucket, messages []Message) error {
+	for _, msg := range messages {
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(msg)
+		if err != nil {
+			return fmt.Errorf("error marshalling history: %w", err)
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, seq)
+		if err := bucket.Put(key, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package user
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FileHistoryStore keeps history as JSON lines in <dir>/<key>.history.jsonl
+type FileHistoryStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+func NewFileHistoryStore(dir string) (*FileHistoryStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating history directory: %w", err)
+	}
+	return &FileHistoryStore{dir: dir}, nil
+}
+
+func (s *FileHistoryStore) path(key string) string {
+	return filepath.Join(s.dir, key+".history.jsonl")
+}
+
+func (s *FileHistoryStore) Load(key string) ([]Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := os.Open(s.path(key))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error opening history file: %w", err)
+	}
+	defer file.Close()
+
+	var messages []Message
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var msg Message
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			return nil, fmt.Errorf("error unmarshalling history: %w", err)
+		}
+		messages = append(messages, msg)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading history file: %w", err)
+	}
+	return messages, nil
+}
+
+func (s *FileHistoryStore) Append(key string, messages ...Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := os.OpenFile(s.path(key), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening history file: %w", err)
+	}
+	defer file.Close()
+
+	return writeMessages(file, messages)
+}
+
+func (s *FileHistoryStore) Save(key string, messages []Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(messages) == 0 {
+		err := os.Remove(s.path(key))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error removing history file: %w", err)
+		}
+		return nil
+	}
+
+	// Пишем во временный файл и переименовываем, чтобы не потерять историю при сбое
+	tmp := s.path(key) + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("error creating history file: %w", err)
+	}
+	if err := writeMessages(file, messages); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("error writing history file: %w", err)
+	}
+	return os.Rename(tmp, s.path(key))
+}
+
+func (s *FileHistoryStore) Close() error {
+	return nil
+}
+
+func writeMessages(file *os.File, messages []Message) error {
+	w := bufio.NewWriter(file)
+	encoder := json.NewEncoder(w)
+	for _, msg := range messages {
+		if err := encoder.Encode(msg); err != nil {
+			return fmt.Errorf("error marshalling history: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("error writing history file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package user
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHistoryStores(t *testing.T) {
+	stores := []struct {
+		name string
+		open func(t *testing.T) HistoryStore
+	}{
+		{"file", func(t *testing.T) HistoryStore {
+			store, err := NewFileHistoryStore(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			return store
+		}},
+		{"bolt", func(t *testing.T) HistoryStore {
+			store, err := NewBoltHistoryStore(filepath.Join(t.TempDir(), "history.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return store
+		}},
+	}
+
+	at := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	question := Message{Role: "user", Content: "привет", Time: at, Images: []string{"photo"}, ChatID: -100, MessageIDs: []int{1}}
+	answer := Message{Role: "assistant", Content: "hi", Time: at, ChatID: -100, MessageIDs: []int{2, 3}}
+	summary := Message{Role: "system", Content: "summary", Time: at, Summary: true}
+
+	steps := []struct {
+		name string
+		do   func(HistoryStore) error
+		key  string
+		want []Message
+	}{
+		{"missing key", nil, "1", nil},
+		{"append", func(s HistoryStore) error { return s.Append("1", question) }, "1", []Message{question}},
+		{"append more", func(s HistoryStore) error { return s.Append("1", answer) }, "1", []Message{question, answer}},
+		{"other key untouched", nil, "1.chat", nil},
+		{"save replaces", func(s HistoryStore) error { return s.Save("1", []Message{summary, answer}) }, "1", []Message{summary, answer}},
+		{"append after save", func(s HistoryStore) error { return s.Append("1", question) }, "1", []Message{summary, answer, question}},
+		{"conversation key", func(s HistoryStore) error { return s.Append("1.chat", answer) }, "1.chat", []Message{answer}},
+		{"save empty clears", func(s HistoryStore) error { return s.Save("1", nil) }, "1", nil},
+		{"clear keeps other key", nil, "1.chat", []Message{answer}},
+		{"save empty missing key", func(s HistoryStore) error { return s.Save("2", nil) }, "2", nil},
+	}
+
+	for _, st := range stores {
+		t.Run(st.name, func(t *testing.T) {
+			store := st.open(t)
+			defer store.Close()
+			for _, step := range steps {
+				if step.do != nil {
+					if err := step.do(store); err != nil {
+						t.Fatalf("%s: %v", step.name, err)
+					}
+				}
+				got, err := store.Load(step.key)
+				if err != nil {
+					t.Fatalf("%s: Load(%q): %v", step.name, step.key, err)
+				}
+				if !reflect.DeepEqual(got, step.want) {
+					t.Errorf("%s: Load(%q)\n got: %+v\nwant: %+v", step.name, step.key, got, step.want)
+				}
+			}
+		})
+	}
+}
+
+func TestFileHistoryStoreReopen(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileHistoryStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := Message{Role: "user", Content: "line one\nline two", Time: time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)}
+	if err := store.Append("1", msg); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewFileHistoryStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := reopened.Load("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Message{msg}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Load after reopen\n got: %+v\nwant: %+v", got, want)
+	}
+}
@@ -0,0 +1,29 @@
+package user
+
+import "unicode"
+
+// Служебные токены, которые API добавляет к каждому сообщению
+const messageTokenOverhead = 4
+
+// EstimateTokens approximates the number of tokens in text without a real
+// tokenizer: about 4 characters per token for Latin text, about 2 for
+// Cyrillic and other alphabets, and one token per CJK character.
+func EstimateTokens(text string) int {
+	var latin, other, cjk int
+	for _, r := range text {
+		switch {
+		case r < unicode.MaxASCII:
+			latin++
+		case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul):
+			cjk++
+		default:
+			other++
+		}
+	}
+	return (latin+3)/4 + (other+1)/2 + cjk
+}
+
+// EstimateMessageTokens approximates the tokens taken by a chat message
+func EstimateMessageTokens(content string) int {
+	return EstimateTokens(content) + messageTokenOverhead
+}
@@ -0,0 +1,28 @@
+package user
+
+import "testing"
+
+func TestEstimateTokens(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want int
+	}{
+		{"empty", "", 0},
+		{"latin four chars", "abcd", 1},
+		{"latin rounds up", "abcde", 2},
+		{"cyrillic", "привет", 3},
+		{"cjk", "日本語", 3},
+		{"mixed", "hi мир 日", 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := EstimateTokens(tt.in); got != tt.want {
+				t.Errorf("EstimateTokens(%q) = %d, want %d", tt.in, got, tt.want)
+			}
+		})
+	}
+	if got, want := EstimateMessageTokens("abcd"), 1+messageTokenOverhead; got != want {
+		t.Errorf("EstimateMessageTokens(%q) = %d, want %d", "abcd", got, want)
+	}
+}
@@ -3,26 +3,33 @@ package user
 import (
 	"sync"
 	"time"
-
-	"github.com/sashabaranov/go-openai"
 )
 
 type UsageTracker struct {
 	UserID          string
 	UserName        string
 	LogsDir         string
-	SystemPrompt    string
 	LastMessageTime time.Time
-	CurrentStream   *openai.ChatCompletionStream
 	Usage           *UserUsage
 	History         History
+	store           HistoryStore
 	UsageMu         sync.Mutex `json:"-"` // Мьютекс для синхронизации доступа к Usage
 	FileMu          sync.Mutex `json:"-"` // Мьютекс для синхронизации доступа к файлу
+	RequestsMu      sync.Mutex `json:"-"` // Мьютекс для синхронизации доступа к активным запросам
+	requests        map[int]*Request
+	requestSeq      int
 }
 
 type Message struct {
-	Role    string
-	Content string
+	Role    string    `json:"role"`
+	Content string    `json:"content"`
+	Time    time.Time `json:"time"`
+	Summary bool      `json:"summary,omitempty"` // Краткое содержание ранних сообщений
+	Images  []string  `json:"images,omitempty"`  // file_id изображений Telegram
+
+	// Сообщения Telegram, в которых было отправлено это сообщение
+	ChatID     int64 `json:"chat_id,omitempty"`
+	MessageIDs []int `json:"message_ids,omitempty"`
 }
 
 type History struct {
@@ -32,7 +39,13 @@ type History struct {
 
 type UserUsage struct {
 	UserName     string    `json:"user_name"`
+	Model        string    `json:"model,omitempty"` // Устаревшее: переносится в разговор по умолчанию
 	UsageHistory UsageHist `json:"usage_history"`
+
+	Conversations       map[string]*Conversation `json:"conversations,omitempty"`
+	CurrentConversation string                   `json:"current_conversation,omitempty"`
+
+	Group *GroupSettings `json:"group,omitempty"` // Настройки группы; только у истории чата
 }
 
 type Cost struct {
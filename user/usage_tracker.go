@@ -1,6 +1,7 @@
 package user
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -26,7 +27,7 @@ func NewUsageTracker(userID, userName, logsDir string, conf *config.Config) *Usa
 		History: History{
 			messages: make([]Message, 0),
 		},
-		SystemPrompt: conf.SystemPrompt,
+		store: memoryHistoryStore{},
 	}
 
 	err := usageTracker.loadUsage()
@@ -259,10 +260,10 @@ func calculateTotalCost(chatCost map[string]float64) float64 {
 }
 
 // GetUsageFromApi Get cost of current generation
-func (ut *UsageTracker) GetUsageFromApi(id string, conf *config.Config) error {
+func (ut *UsageTracker) GetUsageFromApi(ctx context.Context, id string, conf *config.Config) error {
 	url := fmt.Sprintf("https://openrouter.ai/api/v1/generation?id=%s", id)
 
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		log.Printf("Error creating request for user %s: %v", ut.UserID, err)
 		return fmt.Errorf("error creating request: %w", err)
@@ -278,6 +279,11 @@ func (ut *UsageTracker) GetUsageFromApi(id string, conf *config.Config) error {
 		return fmt.Errorf("error sending request: %w", err)
 	}
 	defer resp.Body.Close()
+	// Сразу после стрима OpenRouter часто отвечает 404: тело ошибки разбирается
+	// без ошибок с нулевой стоимостью, поэтому статус проверяется отдельно
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error getting generation %s: %s", id, resp.Status)
+	}
 
 	var generationResponse GenerationResponse
 	err = json.NewDecoder(resp.Body).Decode(&generationResponse)
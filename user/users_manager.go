@@ -3,6 +3,8 @@ package user
 
 import (
 	"openrouter-bot/config"
+	"os"
+	"path/filepath"
 	"strconv"
 	"sync"
 )
@@ -10,13 +12,15 @@ import (
 type Manager struct {
 	LogsDir string
 	users   map[int64]*UsageTracker
+	store   HistoryStore
 	mu      sync.Mutex
 }
 
-func NewUserManager(logsDir string) *Manager {
+func NewUserManager(logsDir string, store HistoryStore) *Manager {
 	return &Manager{
 		LogsDir: logsDir,
 		users:   make(map[int64]*UsageTracker),
+		store:   store,
 	}
 }
 
@@ -29,6 +33,25 @@ func (um *Manager) GetUser(userID int64, userName string, conf *config.Config) *
 	}
 
 	user := NewUsageTracker(strconv.FormatInt(userID, 10), userName, um.LogsDir, conf)
+	user.store = um.store
+	user.loadHistory()
 	um.users[userID] = user
 	return user
 }
+
+// GroupSettings returns the settings of a group chat without creating its usage
+// file: a chat that has never addressed the bot or changed its settings uses
+// the defaults from config.
+func (um *Manager) GroupSettings(chatID int64, title string, conf *config.Config) GroupSettings {
+	um.mu.Lock()
+	group, exists := um.users[chatID]
+	um.mu.Unlock()
+	if !exists {
+		file := filepath.Join(um.LogsDir, strconv.FormatInt(chatID, 10)+".json")
+		if _, err := os.Stat(file); err != nil {
+			return defaultGroupSettings(conf)
+		}
+		group = um.GetUser(chatID, title, conf)
+	}
+	return group.GetGroupSettings(conf)
+}
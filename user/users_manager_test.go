@@ -0,0 +1,36 @@
+package user
+
+import (
+	"openrouter-bot/config"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestManagerGroupSettings(t *testing.T) {
+	dir := t.TempDir()
+	conf := &config.Config{GroupMention: true, GroupReply: true}
+	const chatID = -100
+
+	manager := NewUserManager(dir, memoryHistoryStore{})
+	want := GroupSettings{Mention: true, Reply: true}
+	if got := manager.GroupSettings(chatID, "group", conf); got != want {
+		t.Errorf("GroupSettings of a new chat = %+v, want %+v", got, want)
+	}
+	file := filepath.Join(dir, "-100.json")
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Fatalf("usage file of the chat was created before the bot was addressed: %v", err)
+	}
+
+	changed := GroupSettings{Mention: true, SharedHistory: true}
+	manager.GetUser(chatID, "group", conf).SetGroupSettings(changed)
+	if got := manager.GroupSettings(chatID, "group", conf); got != changed {
+		t.Errorf("GroupSettings after change = %+v, want %+v", got, changed)
+	}
+
+	// После перезапуска настройки читаются из файла чата
+	restarted := NewUserManager(dir, memoryHistoryStore{})
+	if got := restarted.GroupSettings(chatID, "group", conf); got != changed {
+		t.Errorf("GroupSettings after restart = %+v, want %+v", got, changed)
+	}
+}